@@ -1,7 +1,9 @@
 package lru
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/caser789/go-lru/simplelru"
 )
@@ -24,9 +26,18 @@ type ARCCache struct {
 	t2 simplelru.LRUCache // T2 is the LRU for frequently accessed items
 	b2 simplelru.LRUCache // B2 is the LRU for evictions from t2
 
+	maxAge time.Duration // MaxAge bounds how long a value is served, if set
+
 	lock sync.RWMutex
 }
 
+// arcAged is a value stored by an ARC with a max age, along with the time
+// it was added
+type arcAged struct {
+	value interface{}
+	added time.Time
+}
+
 // NewARC creates an ARC of the given size
 func NewARC(size int) (*ARCCache, error) {
 	// Create the sub LRUs
@@ -59,6 +70,45 @@ func NewARC(size int) (*ARCCache, error) {
 	return c, nil
 }
 
+// NewARCWithMaxAge creates an ARC of the given size whose entries are
+// treated as misses once they are older than maxAge, however frequently
+// they are read. Age counts from when the value was last added, so a Get
+// that promotes an entry does not refresh it.
+func NewARCWithMaxAge(size int, maxAge time.Duration) (*ARCCache, error) {
+	c, err := NewARC(size)
+	if err != nil {
+		return nil, err
+	}
+	c.maxAge = maxAge
+	return c, nil
+}
+
+// NewARCNoGhosts creates a reduced ARC of the given size that keeps no
+// ghost lists. Without them there is nothing to adapt to, so P stays fixed
+// at half the size and the cache behaves as a static recent/frequent
+// split. It saves the key-only metadata of B1 and B2 at the cost of ARC's
+// self-tuning.
+func NewARCNoGhosts(size int) (*ARCCache, error) {
+	t1, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	t2, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ARCCache{
+		size: size,
+		p:    size / 2,
+		t1:   t1,
+		b1:   NopCache{},
+		t2:   t2,
+		b2:   NopCache{},
+	}
+	return c, nil
+}
+
 // Get looks up a key's value from the cache.
 func (c *ARCCache) Get(key interface{}) (interface{}, bool) {
 	c.lock.Lock()
@@ -68,13 +118,20 @@ func (c *ARCCache) Get(key interface{}) (interface{}, bool) {
 	// promote it to T2 (frequent)
 	if val, ok := c.t1.Peek(key); ok {
 		c.t1.Remove(key)
+		if !c.fresh(val) {
+			return nil, false
+		}
 		c.t2.Add(key, val)
-		return val, ok
+		return c.unwrap(val), ok
 	}
 
 	// Check if the value is contained in T2 (frequent)
 	if val, ok := c.t2.Get(key); ok {
-		return val, ok
+		if !c.fresh(val) {
+			c.t2.Remove(key)
+			return nil, false
+		}
+		return c.unwrap(val), ok
 	}
 
 	// No hit
@@ -90,13 +147,13 @@ func (c *ARCCache) Add(key, value interface{}) {
 	// promote it to frequent T2
 	if c.t1.Contains(key) {
 		c.t1.Remove(key)
-		c.t2.Add(key, value)
+		c.t2.Add(key, c.wrap(value))
 		return
 	}
 
 	// Check if the value is already in T2 (frequent) and update it
 	if c.t2.Contains(key) {
-		c.t2.Add(key, value)
+		c.t2.Add(key, c.wrap(value))
 		return
 	}
 
@@ -125,7 +182,7 @@ func (c *ARCCache) Add(key, value interface{}) {
 		c.b1.Remove(key)
 
 		// Add the key to the frequently used list
-		c.t2.Add(key, value)
+		c.t2.Add(key, c.wrap(value))
 		return
 	}
 
@@ -154,26 +211,42 @@ func (c *ARCCache) Add(key, value interface{}) {
 		c.b2.Remove(key)
 
 		// Add the key to the frequntly used list
-		c.t2.Add(key, value)
+		c.t2.Add(key, c.wrap(value))
 		return
 	}
 
-	// Potentially need to make room in the cache
-	if c.t1.Len()+c.t2.Len() >= c.size {
-		c.replace(false)
-	}
+	// Make room, keeping T1+B1 within size and the whole directory within
+	// twice size
+	c.trimForMiss()
 
-	// Keep the size of the ghost buffers trim
-	if c.b1.Len() > c.size-c.p {
-		c.b1.RemoveOldest()
+	// Add to the recently seen list
+	c.t1.Add(key, c.wrap(value))
+	return
+}
+
+// trimForMiss makes room for a key that is in none of the lists. If T1 and
+// B1 already fill the size, B1 gives up its oldest ghost, or when B1 is
+// empty T1 drops its oldest entry outright. Otherwise the oldest ghost of B2
+// is dropped once the directory holds twice size.
+func (c *ARCCache) trimForMiss() {
+	t1Len, b1Len := c.t1.Len(), c.b1.Len()
+	if t1Len+b1Len >= c.size {
+		if t1Len < c.size {
+			c.b1.RemoveOldest()
+			if c.t1.Len()+c.t2.Len() >= c.size {
+				c.replace(false)
+			}
+		} else {
+			c.t1.RemoveOldest()
+		}
+		return
 	}
-	if c.b2.Len() > c.p {
+	if t1Len+c.t2.Len()+b1Len+c.b2.Len() >= 2*c.size {
 		c.b2.RemoveOldest()
 	}
-
-	// Add to the recently seen list
-	c.t1.Add(key, value)
-	return
+	if c.t1.Len()+c.t2.Len() >= c.size {
+		c.replace(false)
+	}
 }
 
 // replace is used to adaptively evict from either T1 or T2
@@ -242,7 +315,13 @@ func (c *ARCCache) Purge() {
 func (c *ARCCache) Contains(key interface{}) bool {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return c.t1.Contains(key) || c.t2.Contains(key)
+	if val, ok := c.t1.Peek(key); ok {
+		return c.fresh(val)
+	}
+	if val, ok := c.t2.Peek(key); ok {
+		return c.fresh(val)
+	}
+	return false
 }
 
 // Peek is used to inspect the cache value of a key
@@ -250,8 +329,115 @@ func (c *ARCCache) Contains(key interface{}) bool {
 func (c *ARCCache) Peek(key interface{}) (interface{}, bool) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	if val, ok := c.t1.Peek(key); ok {
-		return val, ok
+	val, ok := c.t1.Peek(key)
+	if !ok {
+		val, ok = c.t2.Peek(key)
+	}
+	if !ok || !c.fresh(val) {
+		return nil, false
+	}
+	return c.unwrap(val), true
+}
+
+// PeekOldest returns the entry the next replacement would most likely
+// evict, without updating recency, frequency or the adaptive state: the
+// tail of T1 if it is non-empty, otherwise the tail of T2.
+func (c *ARCCache) PeekOldest() (key, value interface{}, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	key, val, ok := c.t1.GetOldest()
+	if !ok {
+		key, val, ok = c.t2.GetOldest()
+	}
+	if !ok {
+		return nil, nil, false
+	}
+	return key, c.unwrap(val), true
+}
+
+// TrimGhosts drops the oldest ghost keys until B1 and B2 each hold at most
+// maxEach, shedding ARC's key-only metadata under memory pressure. Cached
+// values are untouched. P is then clamped to the range the remaining
+// history supports, at most len(T1)+len(B1) and at least
+// size-(len(T2)+len(B2)), so a preference learned from dropped ghosts does
+// not outlive them.
+func (c *ARCCache) TrimGhosts(maxEach int) {
+	if maxEach < 0 {
+		maxEach = 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for c.b1.Len() > maxEach {
+		c.b1.RemoveOldest()
+	}
+	for c.b2.Len() > maxEach {
+		c.b2.RemoveOldest()
+	}
+	if low := c.size - (c.t2.Len() + c.b2.Len()); c.p < low {
+		c.p = low
+	}
+	if high := c.t1.Len() + c.b1.Len(); c.p > high {
+		c.p = high
+	}
+}
+
+// SetP overrides P, the target size of T1, clamping it to [0, size]. A
+// high P favours recently added entries and a low one frequently used
+// entries. This is a hook for experiments and tuning: it overrides the
+// balance ARC learned from its ghost hits, and ARC keeps adapting P from
+// the new value on later misses, so the override does not last.
+func (c *ARCCache) SetP(p int) {
+	if p < 0 {
+		p = 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if p > c.size {
+		p = c.size
+	}
+	c.p = p
+}
+
+// checkInvariants verifies the bounds the ARC algorithm keeps on its lists
+// and on P, returning an error describing the first one violated. It is
+// intended for tests.
+func (c *ARCCache) checkInvariants() error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t1, t2, b1, b2 := c.t1.Len(), c.t2.Len(), c.b1.Len(), c.b2.Len()
+	switch {
+	case t1+t2 > c.size:
+		return fmt.Errorf("t1+t2 = %d exceeds size %d", t1+t2, c.size)
+	case t1+b1 > c.size:
+		return fmt.Errorf("t1+b1 = %d exceeds size %d", t1+b1, c.size)
+	case t1+t2+b1+b2 > 2*c.size:
+		return fmt.Errorf("t1+t2+b1+b2 = %d exceeds twice size %d", t1+t2+b1+b2, c.size)
+	case c.p < 0 || c.p > c.size:
+		return fmt.Errorf("p = %d outside [0, %d]", c.p, c.size)
+	}
+	return nil
+}
+
+// wrap stamps a value with the time it was added, if a max age is set
+func (c *ARCCache) wrap(value interface{}) interface{} {
+	if c.maxAge <= 0 {
+		return value
+	}
+	return &arcAged{value: value, added: time.Now()}
+}
+
+// unwrap returns the value stored by wrap
+func (c *ARCCache) unwrap(val interface{}) interface{} {
+	if c.maxAge <= 0 {
+		return val
+	}
+	return val.(*arcAged).value
+}
+
+// fresh reports whether a stored value is still within the max age
+func (c *ARCCache) fresh(val interface{}) bool {
+	if c.maxAge <= 0 {
+		return true
 	}
-	return c.t2.Peek(key)
+	return time.Since(val.(*arcAged).added) < c.maxAge
 }
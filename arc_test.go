@@ -375,3 +375,229 @@ func TestARC_Peek(t *testing.T) {
 		t.Errorf("should not have updated recent-ness of 1")
 	}
 }
+
+func TestARC_NoGhosts(t *testing.T) {
+	l, err := NewARCNoGhosts(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Get(0)
+	l.Get(1)
+	for i := 4; i < 8; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 4 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if l.b1.Len() != 0 || l.b2.Len() != 0 {
+		t.Errorf("no ghosts should be kept")
+	}
+
+	// A recently evicted key is not remembered, and P never adapts
+	if l.Contains(2) {
+		t.Fatalf("2 should have been evicted")
+	}
+	t2Len := l.t2.Len()
+	l.Add(2, 2)
+	if l.t2.Len() > t2Len || !l.t1.Contains(2) {
+		t.Errorf("2 should be added as a recent entry")
+	}
+	if l.p != 2 {
+		t.Errorf("p should stay fixed: %d", l.p)
+	}
+}
+
+func TestARC_MaxAge(t *testing.T) {
+	l, err := NewARCWithMaxAge(4, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Get(2)
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Fatalf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if v, ok := l.Peek(2); !ok || v != 2 {
+		t.Fatalf("2 should be set to 2: %v, %v", v, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	l.Add(3, 3)
+	if _, ok := l.Get(1); ok {
+		t.Errorf("1 should have aged out despite being frequent")
+	}
+	if _, ok := l.Peek(2); ok || l.Contains(2) {
+		t.Errorf("2 should have aged out")
+	}
+	if v, ok := l.Get(3); !ok || v != 3 {
+		t.Errorf("3 should be set to 3: %v, %v", v, ok)
+	}
+
+	// Re-adding a stale key refreshes it
+	l.Add(2, 20)
+	if v, ok := l.Get(2); !ok || v != 20 {
+		t.Errorf("2 should be set to 20: %v, %v", v, ok)
+	}
+}
+
+func TestARC_PeekOldest(t *testing.T) {
+	l, err := NewARC(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, _, ok := l.PeekOldest(); ok {
+		t.Errorf("empty cache should have no oldest entry")
+	}
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Get(1)
+	if k, v, ok := l.PeekOldest(); !ok || k != 2 || v != 2 {
+		t.Errorf("2 should be the oldest in t1: %v, %v, %v", k, v, ok)
+	}
+	l.Get(2)
+	if k, _, ok := l.PeekOldest(); !ok || k != 1 {
+		t.Errorf("1 should be the oldest in t2: %v, %v", k, ok)
+	}
+	if l.t2.Len() != 2 || l.p != 0 {
+		t.Errorf("PeekOldest should not change state")
+	}
+}
+
+// Fuzz random Add/Get/Remove sequences, checking the ARC invariants after
+// every operation. Each pair of bytes is an operation and a key.
+func FuzzARC_Invariants(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 2, 1, 1, 0, 3, 2, 2, 0, 2})
+	f.Add([]byte{0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 1, 0, 2, 1, 5, 0, 6})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		l, err := NewARC(4)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for i := 0; i+1 < len(ops); i += 2 {
+			key := int(ops[i+1] % 16)
+			switch ops[i] % 3 {
+			case 0:
+				l.Add(key, key)
+			case 1:
+				l.Get(key)
+			case 2:
+				l.Remove(key)
+			}
+			if err := l.checkInvariants(); err != nil {
+				t.Fatalf("after op %d: %v", i/2, err)
+			}
+		}
+	})
+}
+
+func TestARC_TrimGhosts(t *testing.T) {
+	l, err := NewARC(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+		l.Get(i)
+	}
+	for i := 8; i < 24; i++ {
+		l.Add(i, i)
+	}
+	if l.b1.Len() <= 2 || l.b2.Len() == 0 {
+		t.Fatalf("ghosts should be populated: %d %d", l.b1.Len(), l.b2.Len())
+	}
+
+	keys := l.Keys()
+	l.TrimGhosts(2)
+	if l.b1.Len() != 2 || l.b2.Len() > 2 {
+		t.Errorf("bad ghost lens: %d %d", l.b1.Len(), l.b2.Len())
+	}
+	if l.Len() != len(keys) {
+		t.Errorf("cached values should be kept: %d", l.Len())
+	}
+	if err := l.checkInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+
+	l.TrimGhosts(-1)
+	if l.b1.Len() != 0 || l.b2.Len() != 0 {
+		t.Errorf("bad ghost lens: %d %d", l.b1.Len(), l.b2.Len())
+	}
+	l.Add(100, 100)
+	if !l.Contains(100) {
+		t.Errorf("cache should work without ghosts")
+	}
+}
+
+// Test that TrimGhosts clamps P to the history left behind
+func TestARC_TrimGhostsClampsP(t *testing.T) {
+	l, err := NewARC(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Fill T2, then push recent keys through T1 into B1 and hit them
+	// again so P grows towards T1
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+		l.Get(i)
+	}
+	for i := 8; i < 16; i++ {
+		l.Add(i, i)
+	}
+	for i := 8; i < 12; i++ {
+		l.Add(i, i)
+	}
+	if l.p == 0 {
+		t.Fatalf("p should have grown")
+	}
+
+	l.TrimGhosts(0)
+	if l.p != l.t1.Len() {
+		t.Errorf("p %d should be clamped to %d", l.p, l.t1.Len())
+	}
+	if low := 8 - l.t2.Len(); l.p < low {
+		t.Errorf("p %d should be at least %d", l.p, low)
+	}
+	if err := l.checkInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
+
+func TestARC_SetP(t *testing.T) {
+	for _, tc := range []struct {
+		p, want, evicted int
+	}{
+		{-1, 0, 1}, // a low P evicts from T1
+		{9, 4, 3},  // a high P evicts from T2
+	} {
+		l, err := NewARC(4)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for i := 1; i <= 4; i++ {
+			l.Add(i, i)
+		}
+		l.Get(3)
+		l.Get(4)
+
+		l.SetP(tc.p)
+		if l.p != tc.want {
+			t.Fatalf("bad p: %d", l.p)
+		}
+		l.Add(5, 5)
+		if l.Contains(tc.evicted) || l.Len() != 4 {
+			t.Errorf("p %d: %d should be evicted: %v", l.p, tc.evicted, l.Keys())
+		}
+		if err := l.checkInvariants(); err != nil {
+			t.Errorf("invariants: %v", err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package lru
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/caser789/go-lru/simplelru"
+)
+
+// AsyncEvictCallbacks configures how NewWithAsyncEvict delivers eviction
+// callbacks.
+type AsyncEvictCallbacks struct {
+	// QueueSize is the number of evictions that may wait for the worker.
+	// A cache operation that leaves more waiting blocks, after releasing
+	// the cache's lock, until the worker catches up. With a QueueSize of 0
+	// every evicting operation waits until the worker has taken its
+	// evictions. Operations made by the callback itself never wait, since
+	// they run on the worker.
+	QueueSize int
+	// DropWhenFull discards an eviction that finds the queue full instead
+	// of blocking the cache operation that caused it until there is room.
+	// With a QueueSize of 0, evictions are only kept while the worker is
+	// idle.
+	DropWhenFull bool
+}
+
+// asyncEvictQueue holds the evictions waiting for the worker. It is
+// unbounded so the cache never blocks on it while holding its lock; the
+// limit is enforced by waiting after the lock is released.
+type asyncEvictQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []KV
+	limit  int
+	drop   bool
+	idle   bool
+	closed bool
+	// stopped is set once the worker has exited, after which evictions
+	// are no longer queued
+	stopped bool
+	done    chan struct{}
+	// worker is the id of the goroutine delivering callbacks, which must
+	// never wait on itself when a callback evicts from the cache
+	worker uint64
+}
+
+// NewWithAsyncEvict constructs a fixed size cache whose eviction callback
+// runs on a worker goroutine instead of inline under the cache's lock, so
+// a slow callback, such as one closing a connection, does not stall other
+// operations, and the callback may call back into the cache, including to
+// add or remove entries. Callbacks are delivered one at a time in eviction
+// order. Call Close to stop the worker once the cache is no longer used.
+func NewWithAsyncEvict(size int, onEvicted func(key interface{}, value interface{}), opts AsyncEvictCallbacks) (*Cache, error) {
+	if opts.QueueSize < 0 {
+		return nil, errors.New("Must provide a non-negative queue size")
+	}
+	q := &asyncEvictQueue{
+		limit: opts.QueueSize,
+		drop:  opts.DropWhenFull,
+		done:  make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	c := &Cache{
+		onEvicted:  onEvicted,
+		evictQueue: q,
+	}
+	lru, err := simplelru.NewLRU(size, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	go q.work(onEvicted)
+	return c, nil
+}
+
+// work delivers queued evictions until the queue is closed and drained
+func (q *asyncEvictQueue) work(onEvicted func(key interface{}, value interface{})) {
+	defer close(q.done)
+	q.mu.Lock()
+	q.worker = goroutineID()
+	for {
+		for len(q.items) == 0 && !q.closed {
+			q.idle = true
+			q.cond.Wait()
+		}
+		q.idle = false
+		if len(q.items) == 0 {
+			q.stopped = true
+			q.mu.Unlock()
+			return
+		}
+		kv := q.items[0]
+		q.items[0] = KV{}
+		q.items = q.items[1:]
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		onEvicted(kv.Key, kv.Value)
+		q.mu.Lock()
+	}
+}
+
+// push hands an eviction to the worker, dropping it if the queue is full
+// and the cache was configured to drop. It never blocks on the worker, so
+// it is safe to call with the cache's lock held. It reports false once the
+// worker has stopped, leaving the caller to deliver the eviction.
+func (q *asyncEvictQueue) push(kv KV) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return false
+	}
+	if q.drop && len(q.items) >= q.limit && !(q.idle && len(q.items) == 0) {
+		return true
+	}
+	q.items = append(q.items, kv)
+	q.cond.Broadcast()
+	return true
+}
+
+// wait blocks while more evictions than the limit are waiting. It is
+// called without the cache's lock held. A callback that evicts from the
+// cache runs on the worker, which would wait on itself, so the worker is
+// let past the limit instead.
+func (q *asyncEvictQueue) wait() {
+	q.mu.Lock()
+	if len(q.items) > q.limit && !q.closed && goroutineID() != q.worker {
+		for len(q.items) > q.limit && !q.closed {
+			q.cond.Wait()
+		}
+	}
+	q.mu.Unlock()
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the
+// "goroutine N [...]" header of its stack trace. It is only used on the
+// slow path, right before wait would block.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// close stops the worker once the queued evictions have been delivered
+func (q *asyncEvictQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+}
+
+// Close stops the eviction worker of a cache built with NewWithAsyncEvict,
+// returning once every queued callback has run, including evictions
+// caused by those callbacks. Evictions after Close run their callback
+// inline. It does nothing for other caches and may be called more than
+// once.
+func (c *Cache) Close() {
+	if c.evictQueue != nil {
+		c.evictQueue.close()
+	}
+}
@@ -0,0 +1,171 @@
+package lru
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncEvict(t *testing.T) {
+	release := make(chan struct{})
+	var evicted []interface{}
+	l, err := NewWithAsyncEvict(1, func(k, v interface{}) {
+		<-release
+		evicted = append(evicted, k)
+	}, AsyncEvictCallbacks{QueueSize: 4})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Adds complete while the callback is stuck
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			l.Add(i, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("adds should not wait for the callback")
+	}
+
+	close(release)
+	l.Close()
+	l.Close()
+	want := []interface{}{0, 1, 2}
+	if len(evicted) != len(want) {
+		t.Fatalf("bad evicted: %v", evicted)
+	}
+	for i := range want {
+		if evicted[i] != want[i] {
+			t.Errorf("callbacks out of order: %v", evicted)
+		}
+	}
+
+	// After Close callbacks run inline
+	l.Add(4, 4)
+	if len(evicted) != 4 || evicted[3] != 3 {
+		t.Errorf("bad evicted: %v", evicted)
+	}
+}
+
+func TestAsyncEvict_DropWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	evicted := 0
+	l, err := NewWithAsyncEvict(1, func(k, v interface{}) {
+		<-release
+		evicted++
+	}, AsyncEvictCallbacks{QueueSize: 1, DropWhenFull: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Add(i, i)
+	}
+	close(release)
+	l.Close()
+	if evicted < 1 || evicted > 2 {
+		t.Errorf("evictions past the queue should be dropped: %d", evicted)
+	}
+}
+
+func TestAsyncEvict_CloseSyncCache(t *testing.T) {
+	l, err := New(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Close()
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if l.Len() != 1 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+// Test that a full queue does not deadlock a callback that uses the cache
+func TestAsyncEvict_ReentrantCallback(t *testing.T) {
+	var l *Cache
+	lens := make(chan int, 16)
+	l, err := NewWithAsyncEvict(1, func(k, v interface{}) {
+		time.Sleep(time.Millisecond)
+		lens <- l.Len()
+	}, AsyncEvictCallbacks{QueueSize: 1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 8; i++ {
+			l.Add(i, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("adds deadlocked on the eviction queue")
+	}
+	l.Close()
+	if n := len(lens); n != 7 {
+		t.Errorf("bad callback count: %d", n)
+	}
+}
+
+func TestAsyncEvict_QueueSize(t *testing.T) {
+	if _, err := NewWithAsyncEvict(1, func(k, v interface{}) {}, AsyncEvictCallbacks{QueueSize: -1}); err == nil {
+		t.Fatalf("should reject a negative queue size")
+	}
+
+	evicted := 0
+	l, err := NewWithAsyncEvict(1, func(k, v interface{}) {
+		evicted++
+	}, AsyncEvictCallbacks{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Close()
+	if evicted != 3 {
+		t.Errorf("an unbuffered queue should deliver every eviction: %d", evicted)
+	}
+}
+
+func TestAsyncEvict_CallbackAdds(t *testing.T) {
+	for _, size := range []int{0, 1} {
+		var l *Cache
+		var calls int32
+		l, err := NewWithAsyncEvict(1, func(k, v interface{}) {
+			// re-adding evicts again, so the callback keeps the queue
+			// at or over its limit and must not wait on itself
+			if n := atomic.AddInt32(&calls, 1); n < 20 {
+				l.Add(k.(int)+100, v)
+			}
+		}, AsyncEvictCallbacks{QueueSize: size})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 8; i++ {
+				l.Add(i, i)
+			}
+			l.Close()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("queue size %d: callback deadlocked adding to the cache", size)
+		}
+		if n := atomic.LoadInt32(&calls); n < 20 {
+			t.Errorf("queue size %d: bad callback count: %d", size, n)
+		}
+	}
+}
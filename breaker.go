@@ -0,0 +1,131 @@
+package lru
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a LoadingCache whose circuit breaker is
+// open, instead of calling the loader.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitState is the state of a LoadingCache's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every load through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails loads fast until the cooldown has elapsed
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe load through, which closes the
+	// circuit if it succeeds and opens it again if it fails
+	CircuitHalfOpen
+)
+
+// String returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker counts consecutive load failures and decides whether loads may
+// run. It is guarded by the LoadingCache's mu.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	failures     int
+	firstFailure time.Time
+	open         bool
+	openedAt     time.Time
+	probing      bool
+}
+
+// SetCircuitBreaker guards the loader with a circuit breaker. After
+// threshold consecutive loader errors within window, the circuit opens and
+// loads fail with ErrCircuitOpen without calling the loader, falling back
+// to the fallback loader if one is set. In a stale-while-revalidate cache
+// the stale value, however old, is returned instead of ErrCircuitOpen
+// where there is one. Once cooldown has elapsed a single probe load is let
+// through, closing the circuit if it succeeds. The bulk loader is guarded
+// by the same breaker. A non-positive threshold removes the breaker.
+func (c *LoadingCache[K, V]) SetCircuitBreaker(threshold int, window, cooldown time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if threshold <= 0 {
+		c.breaker = nil
+		return
+	}
+	c.breaker = &breaker{threshold: threshold, window: window, cooldown: cooldown}
+}
+
+// BreakerState returns the state of the circuit breaker, for monitoring.
+// It is CircuitClosed when there is no breaker.
+func (c *LoadingCache[K, V]) BreakerState() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker
+	switch {
+	case b == nil || !b.open:
+		return CircuitClosed
+	case b.probing || time.Since(b.openedAt) >= b.cooldown:
+		return CircuitHalfOpen
+	}
+	return CircuitOpen
+}
+
+// allowLoad reports whether the breaker lets a load run now, claiming the
+// probe if the cooldown has elapsed
+func (c *LoadingCache[K, V]) allowLoad() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker
+	if b == nil || !b.open {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// recordLoad feeds the outcome of a load allowed by allowLoad to the
+// breaker
+func (c *LoadingCache[K, V]) recordLoad(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		return
+	}
+	if b.probing {
+		b.probing = false
+		b.openedAt = now
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open = true
+		b.openedAt = now
+	}
+}
@@ -0,0 +1,136 @@
+package lru
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int32
+	var failing int32 = 1
+	l, err := NewLoading(4, func(k int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return 0, errors.New("backend down")
+		}
+		return k, nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetCircuitBreaker(3, time.Minute, 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Get(i); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("should return the loader error: %v", err)
+		}
+	}
+	if s := l.BreakerState(); s != CircuitOpen {
+		t.Fatalf("bad state: %v", s)
+	}
+
+	// While open the loader is not called
+	if _, err := l.Get(4); err != ErrCircuitOpen {
+		t.Errorf("should fail fast: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("loader should not be called: %d calls", n)
+	}
+
+	// A failed probe opens the circuit again
+	time.Sleep(30 * time.Millisecond)
+	if s := l.BreakerState(); s != CircuitHalfOpen {
+		t.Errorf("bad state: %v", s)
+	}
+	l.Get(5)
+	if s := l.BreakerState(); s != CircuitOpen || atomic.LoadInt32(&calls) != 4 {
+		t.Errorf("failed probe should reopen: %v", s)
+	}
+
+	// A successful probe closes it
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(30 * time.Millisecond)
+	if v, err := l.Get(6); err != nil || v != 6 {
+		t.Errorf("bad probe value: %v %v", v, err)
+	}
+	if s := l.BreakerState(); s != CircuitClosed {
+		t.Errorf("bad state: %v", s)
+	}
+
+	l.SetCircuitBreaker(0, 0, 0)
+	if s := l.BreakerState(); s != CircuitClosed {
+		t.Errorf("bad state: %v", s)
+	}
+}
+
+// Test that failures spread wider than the window do not open the circuit
+func TestCircuitBreaker_Window(t *testing.T) {
+	l, err := NewLoading(4, func(k int) (int, error) {
+		return 0, errors.New("backend down")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetCircuitBreaker(2, 10*time.Millisecond, time.Hour)
+
+	l.Get(1)
+	time.Sleep(20 * time.Millisecond)
+	l.Get(2)
+	if s := l.BreakerState(); s != CircuitClosed {
+		t.Errorf("bad state: %v", s)
+	}
+	l.Get(3)
+	if s := l.BreakerState(); s != CircuitOpen {
+		t.Errorf("bad state: %v", s)
+	}
+}
+
+// Test that an open circuit falls back to the fallback loader or a stale
+// value
+func TestCircuitBreaker_Fallbacks(t *testing.T) {
+	var failing int32
+	l, err := NewLoadingWithStaleWhileRevalidate(4, func(k int) (int, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			return 0, errors.New("backend down")
+		}
+		return k, nil
+	}, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetCircuitBreaker(1, time.Minute, time.Hour)
+
+	if v, err := l.Get(1); err != nil || v != 1 {
+		t.Fatalf("bad value: %v %v", v, err)
+	}
+	atomic.StoreInt32(&failing, 1)
+	l.Get(2)
+	if s := l.BreakerState(); s != CircuitOpen {
+		t.Fatalf("bad state: %v", s)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if v, err := l.Get(1); err != nil || v != 1 {
+		t.Errorf("stale value should be served: %v %v", v, err)
+	}
+	if _, err := l.Get(3); err != ErrCircuitOpen {
+		t.Errorf("should fail fast: %v", err)
+	}
+
+	l.SetFallbackLoader(func(k int) (int, error) {
+		return -k, nil
+	}, 0)
+	if v, err := l.Get(3); err != nil || v != -3 {
+		t.Errorf("bad fallback value: %v %v", v, err)
+	}
+
+	l.SetBulkLoader(func(keys []int) (map[int]int, error) {
+		t.Errorf("bulk loader should not be called")
+		return nil, nil
+	})
+	if _, err := l.GetAll([]int{4}); err != ErrCircuitOpen {
+		t.Errorf("bulk load should fail fast: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package lru
+
+// BytesCache is a thread-safe fixed size LRU cache of byte slices that
+// never lets callers alias the cached bytes: Add stores a copy of its
+// input and Get returns a fresh copy. Each of those pays for an allocation
+// and a copy of the value; Peek returns the cached slice itself for
+// read-only fast paths.
+type BytesCache struct {
+	lru *Cache
+}
+
+// NewBytesCache creates a BytesCache of the given size
+func NewBytesCache(size int) (*BytesCache, error) {
+	lru, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &BytesCache{lru: lru}, nil
+}
+
+// Add adds a copy of value to the cache.  Returns true if an eviction
+// occurred.
+func (c *BytesCache) Add(key interface{}, value []byte) bool {
+	return c.lru.Add(key, copyBytes(value))
+}
+
+// Get looks up a key's value from the cache, returning a copy the caller
+// is free to modify.
+func (c *BytesCache) Get(key interface{}) ([]byte, bool) {
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return copyBytes(v.([]byte)), true
+}
+
+// Peek returns the cached slice without copying it or updating the
+// "recently used"-ness of the key. The slice must not be modified.
+func (c *BytesCache) Peek(key interface{}) ([]byte, bool) {
+	v, ok := c.lru.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (c *BytesCache) Contains(key interface{}) bool {
+	return c.lru.Contains(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *BytesCache) Remove(key interface{}) {
+	c.lru.Remove(key)
+}
+
+// Len returns the number of items in the cache.
+func (c *BytesCache) Len() int {
+	return c.lru.Len()
+}
+
+// Purge is used to completely clear the cache
+func (c *BytesCache) Purge() {
+	c.lru.Purge()
+}
+
+// copyBytes returns a copy of b, preserving nil
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
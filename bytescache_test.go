@@ -0,0 +1,41 @@
+package lru
+
+import "testing"
+
+func TestBytesCache(t *testing.T) {
+	l, err := NewBytesCache(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	in := []byte("abc")
+	l.Add(1, in)
+	in[0] = 'x'
+
+	v, ok := l.Get(1)
+	if !ok || string(v) != "abc" {
+		t.Fatalf("Add should have stored a copy: %q", v)
+	}
+	v[0] = 'y'
+
+	v, ok = l.Peek(1)
+	if !ok || string(v) != "abc" {
+		t.Fatalf("Get should have returned a copy: %q", v)
+	}
+	if p, _ := l.Peek(1); &p[0] != &v[0] {
+		t.Errorf("Peek should return the cached slice")
+	}
+
+	l.Add(2, nil)
+	if v, ok := l.Get(2); !ok || v != nil {
+		t.Errorf("nil should be preserved: %v, %v", v, ok)
+	}
+	if !l.Contains(2) || l.Len() != 2 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	l.Remove(2)
+	l.Purge()
+	if l.Len() != 0 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
@@ -0,0 +1,115 @@
+package lru
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/caser789/go-lru/simplelru"
+)
+
+// sketchPrecision is the number of hash bits used to pick a register. With
+// 2^12 one-byte registers the estimate has a standard error of about 1.6%.
+const sketchPrecision = 12
+
+// keySketch is a HyperLogLog sketch estimating how many distinct keys have
+// been added, in constant memory however many there are.
+type keySketch struct {
+	registers [1 << sketchPrecision]uint8
+}
+
+// NewWithKeyCardinality constructs a fixed size cache that also estimates
+// how many distinct keys have ever been added to it, for DistinctKeysSeen.
+// Each Add hashes its key into a small fixed size sketch, so the extra
+// memory does not grow with the key space.
+func NewWithKeyCardinality(size int) (*Cache, error) {
+	c := &Cache{keysSeen: &keySketch{}}
+	lru, err := simplelru.NewLRUWithOnAdd(size, c.onEvict, func(key, value interface{}, isUpdate bool) {
+		if !isUpdate {
+			c.keysSeen.insert(key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// DistinctKeysSeen estimates the number of distinct keys added over the
+// cache's lifetime, including keys since evicted or removed. A count far
+// above the cache size means the key space is too large for the cache to
+// be effective. It is 0 unless the cache was constructed with
+// NewWithKeyCardinality.
+func (c *Cache) DistinctKeysSeen() uint64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.keysSeen == nil {
+		return 0
+	}
+	return c.keysSeen.estimate()
+}
+
+// insert records a key in the sketch
+func (s *keySketch) insert(key interface{}) {
+	h := hashKey(key)
+	idx := h >> (64 - sketchPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1))) + 1
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+// estimate returns the HyperLogLog cardinality estimate, falling back to
+// linear counting while many registers are still empty
+func (s *keySketch) estimate() uint64 {
+	m := float64(len(s.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range s.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// hashKey returns a 64-bit hash of a key, with fast paths for the common
+// key types
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], k)
+		h.Write(buf[:])
+	default:
+		fmt.Fprintf(h, "%T:%v", key, key)
+	}
+	return mix64(h.Sum64())
+}
+
+// mix64 spreads the bits of an FNV hash, whose high bits are weak for short
+// inputs, so that they can be used to pick a register
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,51 @@
+package lru
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDistinctKeysSeen(t *testing.T) {
+	l, err := NewWithKeyCardinality(128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if n := l.DistinctKeysSeen(); n != 0 {
+		t.Errorf("nothing added yet: %d", n)
+	}
+	for i := 0; i < 100000; i++ {
+		l.Add(i, i)
+		l.Add(i/2, i)
+	}
+	if n := l.DistinctKeysSeen(); n < 95000 || n > 105000 {
+		t.Errorf("estimate too far from 100000: %d", n)
+	}
+	if l.Len() != 128 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+func TestDistinctKeysSeen_Small(t *testing.T) {
+	l, err := NewWithKeyCardinality(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		l.Add(fmt.Sprintf("key-%d", i%20), i)
+		l.Add(struct{ a, b int }{i % 10, 1}, i)
+	}
+	if n := l.DistinctKeysSeen(); n < 29 || n > 31 {
+		t.Errorf("estimate too far from 30: %d", n)
+	}
+
+	plain, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	plain.Add(1, 1)
+	if n := plain.DistinctKeysSeen(); n != 0 {
+		t.Errorf("should not be tracked: %d", n)
+	}
+}
@@ -0,0 +1,28 @@
+package lru
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// WriteCSV writes the cached entries to w as header-less CSV, one row per
+// entry from oldest to newest, with format turning each entry into its
+// columns. It does not update recent-ness. The lock is held for the whole
+// walk, and the first write error aborts it and is returned.
+func (c *Cache) WriteCSV(w io.Writer, format func(key, value interface{}) []string) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	cw := csv.NewWriter(w)
+	for _, k := range c.lru.Keys() {
+		v, ok := c.lru.Peek(k)
+		if !ok {
+			continue
+		}
+		if err := cw.Write(format(k, c.copyValue(v))); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,90 @@
+package lru
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func formatCSV(k, v interface{}) []string {
+	return []string{fmt.Sprint(k), fmt.Sprint(v)}
+}
+
+func TestWriteCSV(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, "plain")
+	l.Add(2, `with "quotes", and commas`)
+	l.Add(3, "three")
+	l.Get(1)
+
+	var buf bytes.Buffer
+	if err := l.WriteCSV(&buf, formatCSV); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := "2,\"with \"\"quotes\"\", and commas\"\n3,three\n1,plain\n"
+	if buf.String() != want {
+		t.Errorf("bad csv:\n%s", buf.String())
+	}
+	if keys := l.Keys(); keys[0] != 2 {
+		t.Errorf("WriteCSV should not update recent-ness: %v", keys)
+	}
+}
+
+func TestWriteCSV_Copy(t *testing.T) {
+	l, err := NewWithCopyFunc(2, func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, []int{1})
+	err = l.WriteCSV(io.Discard, func(k, v interface{}) []string {
+		v.([]int)[0] = 100
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v, _ := l.Peek(1); v.([]int)[0] != 1 {
+		t.Errorf("WriteCSV should have passed a copy: %v", v)
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n += len(p); w.n > 10 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestWriteCSV_Error(t *testing.T) {
+	l, err := New(8192)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 8192; i++ {
+		l.Add(i, strings.Repeat("x", 16))
+	}
+
+	calls := 0
+	err = l.WriteCSV(&failingWriter{}, func(k, v interface{}) []string {
+		calls++
+		return formatCSV(k, v)
+	})
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("bad err: %v", err)
+	}
+	if calls == l.Len() {
+		t.Errorf("walk should abort on the first error")
+	}
+}
@@ -0,0 +1,37 @@
+package lru
+
+import (
+	"sync"
+
+	"github.com/caser789/go-lru/simplelru"
+)
+
+// NewWithFastContains constructs a fixed size cache that also keeps its keys
+// in a sync.Map, so ContainsFast can check membership without taking the
+// cache's lock. Every add and removal pays for the extra map update.
+func NewWithFastContains(size int) (*Cache, error) {
+	c := &Cache{members: &sync.Map{}}
+	lru, err := simplelru.NewLRUWithOnAdd(size, c.onEvict, func(key, value interface{}, isUpdate bool) {
+		c.members.Store(key, struct{}{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// ContainsFast checks if a key is in the cache without taking the cache's
+// lock or updating the recent-ness. The key set is updated under the lock
+// as entries are added and removed, so while other goroutines are changing
+// the cache the answer may lag behind them. An expired entry counts until
+// it is removed. A key being loaded by GetOrLoad is absent until its load
+// completes, while an AddPlaceholder placeholder counts as present.
+// Caches not constructed with NewWithFastContains fall back to Contains.
+func (c *Cache) ContainsFast(key interface{}) bool {
+	if c.members == nil {
+		return c.Contains(key)
+	}
+	_, ok := c.members.Load(key)
+	return ok
+}
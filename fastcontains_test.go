@@ -0,0 +1,89 @@
+package lru
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestContainsFast(t *testing.T) {
+	l, err := NewWithFastContains(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.ContainsFast(1) || !l.ContainsFast(2) {
+		t.Fatalf("should contain 1 and 2")
+	}
+	l.Add(3, 3)
+	if l.ContainsFast(1) {
+		t.Errorf("evicted key should be gone")
+	}
+	l.Remove(2)
+	if l.ContainsFast(2) {
+		t.Errorf("removed key should be gone")
+	}
+	l.Purge()
+	if l.ContainsFast(3) {
+		t.Errorf("purged key should be gone")
+	}
+
+	l.GetOrLoad(4, func() (interface{}, error) {
+		if l.ContainsFast(4) {
+			t.Errorf("key being loaded should be absent")
+		}
+		return 4, nil
+	})
+	if !l.ContainsFast(4) {
+		t.Errorf("loaded key should be present")
+	}
+	l.AddPlaceholder(5)
+	if !l.ContainsFast(5) {
+		t.Errorf("placeholder should be present")
+	}
+
+	plain, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	plain.Add(1, 1)
+	if !plain.ContainsFast(1) || plain.ContainsFast(2) {
+		t.Errorf("should fall back to Contains")
+	}
+}
+
+// Test that ContainsFast is safe alongside concurrent writers
+func TestContainsFast_Concurrent(t *testing.T) {
+	l, err := NewWithFastContains(16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				l.Add(g*1000+i, i)
+				l.ContainsFast(i)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, k := range l.Keys() {
+		if !l.ContainsFast(k) {
+			t.Errorf("should contain %v", k)
+		}
+	}
+	n := 0
+	l.members.Range(func(k, v interface{}) bool {
+		n++
+		return true
+	})
+	if n != l.Len() {
+		t.Errorf("key set has %d keys, cache %d", n, l.Len())
+	}
+}
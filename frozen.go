@@ -0,0 +1,49 @@
+package lru
+
+// FrozenCache is an immutable snapshot of a Cache. It is backed by a plain
+// map and takes no locks, so any number of goroutines can read it at once.
+// Changes to the original cache are not reflected in it.
+type FrozenCache struct {
+	items map[interface{}]interface{}
+	keys  []interface{}
+}
+
+// Freeze returns an immutable snapshot of the cache's current contents.
+func (c *Cache) Freeze() *FrozenCache {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	f := &FrozenCache{
+		items: make(map[interface{}]interface{}, c.lru.Len()),
+	}
+	for _, k := range c.lru.Keys() {
+		if v, ok := c.lru.Peek(k); ok {
+			f.items[k] = c.copyValue(v)
+			f.keys = append(f.keys, k)
+		}
+	}
+	return f
+}
+
+// Get looks up a key's value from the snapshot.
+func (f *FrozenCache) Get(key interface{}) (interface{}, bool) {
+	v, ok := f.items[key]
+	return v, ok
+}
+
+// Contains checks if a key is in the snapshot.
+func (f *FrozenCache) Contains(key interface{}) bool {
+	_, ok := f.items[key]
+	return ok
+}
+
+// Len returns the number of items in the snapshot.
+func (f *FrozenCache) Len() int {
+	return len(f.items)
+}
+
+// Keys returns a slice of the keys in the snapshot, from oldest to newest
+// as of when it was taken.
+func (f *FrozenCache) Keys() []interface{} {
+	return append([]interface{}(nil), f.keys...)
+}
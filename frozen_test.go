@@ -0,0 +1,32 @@
+package lru
+
+import "testing"
+
+func TestFrozenCache(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	f := l.Freeze()
+
+	l.Add(3, 3)
+	l.Remove(1)
+	if f.Len() != 2 || !f.Contains(1) || f.Contains(3) {
+		t.Errorf("snapshot should not see later changes")
+	}
+	if v, ok := f.Get(2); !ok || v != 2 {
+		t.Errorf("2 should be set to 2: %v, %v", v, ok)
+	}
+
+	keys := f.Keys()
+	if len(keys) != 2 || keys[0] != 1 || keys[1] != 2 {
+		t.Errorf("bad keys: %v", keys)
+	}
+	keys[0] = 100
+	if f.Keys()[0] != 1 {
+		t.Errorf("Keys should return a copy")
+	}
+}
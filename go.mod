@@ -1,3 +1,3 @@
 module github.com/caser789/go-lru
 
-go 1.16
+go 1.18
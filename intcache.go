@@ -0,0 +1,134 @@
+package lru
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// IntCache is a thread-safe fixed size LRU cache specialised for int64
+// keys. Keys are held unboxed, so lookups and inserts avoid the allocation
+// of wrapping each key in an interface{}.
+type IntCache struct {
+	size      int
+	evictList *list.List
+	items     map[int64]*list.Element
+	lock      sync.Mutex
+}
+
+// intEntry is used to hold a value in the evictList
+type intEntry struct {
+	key   int64
+	value interface{}
+}
+
+// NewIntCache creates an IntCache of the given size
+func NewIntCache(size int) (*IntCache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size")
+	}
+	c := &IntCache{
+		size:      size,
+		evictList: list.New(),
+		items:     make(map[int64]*list.Element),
+	}
+	return c, nil
+}
+
+// Add adds a value to the cache.  Returns true if an eviction occurred.
+func (c *IntCache) Add(key int64, value interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		c.evictList.MoveToFront(ent)
+		ent.Value.(*intEntry).value = value
+		return false
+	}
+
+	c.items[key] = c.evictList.PushFront(&intEntry{key, value})
+	if c.evictList.Len() <= c.size {
+		return false
+	}
+	c.removeElement(c.evictList.Back())
+	return true
+}
+
+// Get looks up a key's value from the cache.
+func (c *IntCache) Get(key int64) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		c.evictList.MoveToFront(ent)
+		return ent.Value.(*intEntry).value, true
+	}
+	return nil, false
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *IntCache) Peek(key int64) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		return ent.Value.(*intEntry).value, true
+	}
+	return nil, false
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (c *IntCache) Contains(key int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// Remove removes the provided key from the cache, returning if the
+// key was contained.
+func (c *IntCache) Remove(key int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		c.removeElement(ent)
+		return true
+	}
+	return false
+}
+
+// Keys returns a slice of the keys in the cache, from oldest to newest.
+func (c *IntCache) Keys() []int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]int64, 0, len(c.items))
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		keys = append(keys, ent.Value.(*intEntry).key)
+	}
+	return keys
+}
+
+// Len returns the number of items in the cache.
+func (c *IntCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.evictList.Len()
+}
+
+// Purge is used to completely clear the cache
+func (c *IntCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = make(map[int64]*list.Element)
+	c.evictList.Init()
+}
+
+// removeElement is used to remove a given list element from the cache
+func (c *IntCache) removeElement(e *list.Element) {
+	c.evictList.Remove(e)
+	delete(c.items, e.Value.(*intEntry).key)
+}
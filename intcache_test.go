@@ -0,0 +1,75 @@
+package lru
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func BenchmarkIntCache_Rand(b *testing.B) {
+	l, err := NewIntCache(8192)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		trace[i] = rand.Int63() % 32768
+	}
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			_, ok := l.Get(trace[i])
+			if ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
+}
+
+func TestIntCache(t *testing.T) {
+	l, err := NewIntCache(128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := int64(0); i < 256; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 128 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	for i, k := range l.Keys() {
+		if v, ok := l.Get(k); !ok || v != k || k != int64(i)+128 {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+	for i := int64(0); i < 128; i++ {
+		if _, ok := l.Get(i); ok {
+			t.Fatalf("should be evicted")
+		}
+	}
+	for i := int64(128); i < 192; i++ {
+		if !l.Remove(i) || l.Contains(i) {
+			t.Fatalf("should be deleted")
+		}
+	}
+	if v, ok := l.Peek(200); !ok || v != int64(200) {
+		t.Fatalf("200 should be set to 200: %v, %v", v, ok)
+	}
+
+	l.Purge()
+	if l.Len() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if _, ok := l.Get(200); ok {
+		t.Fatalf("should contain nothing")
+	}
+}
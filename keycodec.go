@@ -0,0 +1,24 @@
+package lru
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+// EncodeKeys writes the cached keys to w as a gob stream, newest first,
+// without their values. It lets a peer pre-warm its own cache by loading
+// the same keys from the backing store, hottest first. Expired keys and
+// keys invalidated by Bump are left out. Keys of types other than the gob
+// basic types must be registered with gob.Register on both sides.
+func (c *Cache) EncodeKeys(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(c.KeysNewestFirst())
+}
+
+// DecodeKeys reads a key list written by EncodeKeys, newest first.
+func DecodeKeys(r io.Reader) ([]interface{}, error) {
+	var keys []interface{}
+	if err := gob.NewDecoder(r).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
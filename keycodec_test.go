@@ -0,0 +1,92 @@
+package lru
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+type keycodecKey struct {
+	Tenant string
+	ID     int
+}
+
+func TestEncodeKeys(t *testing.T) {
+	gob.Register(keycodecKey{})
+
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add(1, "large value")
+	l.Add("two", "large value")
+	l.Add(keycodecKey{"a", 3}, "large value")
+	l.Get(1)
+
+	var buf bytes.Buffer
+	if err := l.EncodeKeys(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("large value")) {
+		t.Errorf("values should not be encoded")
+	}
+
+	keys, err := DecodeKeys(&buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := []interface{}{1, keycodecKey{"a", 3}, "two"}
+	if len(keys) != len(want) {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("bad key %d: %v, want %v", i, keys[i], want[i])
+		}
+	}
+
+	if _, err := DecodeKeys(bytes.NewReader([]byte("junk"))); err == nil {
+		t.Errorf("should fail on a bad stream")
+	}
+}
+
+func TestEncodeKeys_Empty(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := l.EncodeKeys(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, err := DecodeKeys(&buf)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("bad keys: %v %v", keys, err)
+	}
+}
+
+// Test that stale keys are not sent for warming
+func TestEncodeKeys_Stale(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add(1, 1)
+	l.Bump()
+	l.Add(2, 2)
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Second))
+
+	var buf bytes.Buffer
+	if err := l.EncodeKeys(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, err := DecodeKeys(&buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != 2 {
+		t.Errorf("only 2 should be encoded: %v", keys)
+	}
+}
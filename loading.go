@@ -0,0 +1,387 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errNotLoaded is the result of a key that was part of a bulk load but
+// missing from what the bulk loader returned
+var errNotLoaded = errors.New("key not returned by bulk loader")
+
+// LoadingCache is a thread-safe fixed size LRU cache with typed keys and
+// values that fills itself using a loader. Get loads a missing key, and
+// concurrent Gets of the same missing key share a single load. Errors are
+// returned but not cached.
+type LoadingCache[K comparable, V any] struct {
+	lru        *Cache
+	loader     func(key K) (V, error)
+	bulkLoader func(keys []K) (map[K]V, error)
+
+	// fallback is tried when the loader fails; its values are fresh for
+	// fallbackTTL when that is positive
+	fallback    func(key K) (V, error)
+	fallbackTTL time.Duration
+
+	breaker *breaker
+
+	// revalidate is set by NewLoadingWithStaleWhileRevalidate, in which
+	// case values are stored as loaded[V] and loads are tracked in calls
+	revalidate bool
+	ttl        time.Duration
+	maxStale   time.Duration
+
+	mu       sync.Mutex
+	calls    map[K]*loadingCall[V]
+	observer func(key K, duration time.Duration, err error)
+}
+
+// loaded is a value stored along with the time it was loaded
+type loaded[V any] struct {
+	value V
+	at    time.Time
+}
+
+// loadingCall is an in-flight load that concurrent Gets wait on
+type loadingCall[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// NewLoading creates a LoadingCache of the given size that calls loader
+// to fetch missing keys
+func NewLoading[K comparable, V any](size int, loader func(key K) (V, error)) (*LoadingCache[K, V], error) {
+	lru, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadingCache[K, V]{lru: lru, loader: loader, calls: make(map[K]*loadingCall[V])}, nil
+}
+
+// NewLoadingWithStaleWhileRevalidate creates a LoadingCache whose values
+// are fresh for ttl after loading. For up to maxStale after that, Get
+// still returns the stale value immediately while a single background
+// load refreshes it. Beyond maxStale, Get blocks until a load completes,
+// joining the background one if it is still running, which bounds how
+// stale a returned value can be. A failed refresh keeps the stale value.
+func NewLoadingWithStaleWhileRevalidate[K comparable, V any](size int, loader func(key K) (V, error), ttl, maxStale time.Duration) (*LoadingCache[K, V], error) {
+	c, err := NewLoading(size, loader)
+	if err != nil {
+		return nil, err
+	}
+	c.revalidate = true
+	c.ttl = ttl
+	c.maxStale = maxStale
+	return c, nil
+}
+
+// Get looks up a key's value from the cache, loading it on a miss.
+func (c *LoadingCache[K, V]) Get(key K) (value V, err error) {
+	if !c.revalidate {
+		var ttl time.Duration
+		v, err := c.lru.GetOrLoad(key, func() (interface{}, error) {
+			var v V
+			v, ttl, err = c.loadValue(key)
+			return v, err
+		})
+		if err != nil {
+			return value, err
+		}
+		value, _ = v.(V)
+		if ttl > 0 {
+			c.lru.AddExpireAt(key, value, time.Now().Add(ttl))
+		}
+		return value, nil
+	}
+
+	if v, ok := c.cached(key); ok {
+		return v, nil
+	}
+	call := c.load(key)
+	call.wg.Wait()
+	if call.err == ErrCircuitOpen {
+		if v, ok := c.lru.Peek(key); ok {
+			return v.(loaded[V]).value, nil
+		}
+	}
+	return call.value, call.err
+}
+
+// cached looks up a key without loading it. In a stale-while-revalidate
+// cache a value past its ttl but within maxStale is returned while a
+// background load refreshes it.
+func (c *LoadingCache[K, V]) cached(key K) (value V, ok bool) {
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return value, false
+	}
+	if !c.revalidate {
+		value, _ = v.(V)
+		return value, true
+	}
+	e := v.(loaded[V])
+	age := time.Since(e.at)
+	if age < c.ttl {
+		return e.value, true
+	}
+	if age < c.ttl+c.maxStale {
+		c.load(key)
+		return e.value, true
+	}
+	return value, false
+}
+
+// SetBulkLoader sets fn to load all of GetAll's misses in one call, for
+// backends that fetch many keys more cheaply than one at a time. Keys
+// missing from the map fn returns are left out of GetAll's result and are
+// not cached. A nil fn makes GetAll load misses one at a time.
+func (c *LoadingCache[K, V]) SetBulkLoader(fn func(keys []K) (map[K]V, error)) {
+	c.mu.Lock()
+	c.bulkLoader = fn
+	c.mu.Unlock()
+}
+
+// GetAll looks up many keys, loading all the misses with a single call to
+// the bulk loader and caching the results. Keys already being loaded by
+// another GetAll, or by Get in a stale-while-revalidate cache, are waited
+// for rather than loaded again. If the bulk loader fails its error is
+// returned and nothing it was asked for is cached. Without a bulk loader,
+// misses are loaded one at a time as by Get.
+func (c *LoadingCache[K, V]) GetAll(keys []K) (map[K]V, error) {
+	values := make(map[K]V, len(keys))
+	c.mu.Lock()
+	bulk := c.bulkLoader
+	c.mu.Unlock()
+	if bulk == nil {
+		for _, key := range keys {
+			v, err := c.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = v
+		}
+		return values, nil
+	}
+
+	var missing []K
+	own := make(map[K]*loadingCall[V])
+	waits := make(map[K]*loadingCall[V])
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if v, ok := c.cached(key); ok {
+			values[key] = v
+		}
+	}
+	c.mu.Lock()
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if _, ok := waits[key]; ok {
+			continue
+		}
+		call, ok := c.calls[key]
+		if !ok {
+			call = &loadingCall[V]{}
+			call.wg.Add(1)
+			c.calls[key] = call
+			own[key] = call
+			missing = append(missing, key)
+		}
+		waits[key] = call
+	}
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		c.loadAll(bulk, missing, own)
+	}
+
+	for key, call := range waits {
+		call.wg.Wait()
+		switch call.err {
+		case nil:
+			values[key] = call.value
+		case errNotLoaded:
+		default:
+			return nil, call.err
+		}
+	}
+	return values, nil
+}
+
+// loadAll runs bulk for the keys GetAll claimed and settles their calls.
+// Settling is deferred so a panicking bulk loader still releases everyone
+// waiting on those keys, with errLoadPanicked, before the panic reaches
+// the caller.
+func (c *LoadingCache[K, V]) loadAll(bulk func(keys []K) (map[K]V, error), missing []K, own map[K]*loadingCall[V]) {
+	var found map[K]V
+	err := ErrCircuitOpen
+	allowed := c.allowLoad()
+	defer func() {
+		if allowed {
+			c.recordLoad(err)
+		}
+		for _, key := range missing {
+			call := own[key]
+			v, ok := found[key]
+			switch {
+			case err != nil:
+				call.err = err
+			case !ok:
+				call.err = errNotLoaded
+			default:
+				call.value = v
+				c.Add(key, v)
+			}
+		}
+		c.mu.Lock()
+		for _, key := range missing {
+			delete(c.calls, key)
+		}
+		c.mu.Unlock()
+		for _, key := range missing {
+			own[key].wg.Done()
+		}
+	}()
+	if allowed {
+		err = errLoadPanicked
+		found, err = bulk(missing)
+	}
+}
+
+// load starts a background load of key, or returns the one in flight
+func (c *LoadingCache[K, V]) load(key K) *loadingCall[V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call
+	}
+	call := &loadingCall[V]{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	go func() {
+		var ttl time.Duration
+		call.value, ttl, call.err = c.loadValue(key)
+		if call.err == nil {
+			at := time.Now()
+			if ttl > 0 && ttl < c.ttl {
+				// Make the value due for refresh after ttl
+				at = at.Add(ttl - c.ttl)
+			}
+			c.lru.Add(key, loaded[V]{value: call.value, at: at})
+		}
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		call.wg.Done()
+	}()
+	return call
+}
+
+// SetLoadObserver sets fn to be called after every loader call with the
+// key, how long the loader took and the error it returned, for example to
+// record tracing spans. A nil fn removes the observer. fn runs on the
+// goroutine that called the loader, before waiting Gets are released.
+func (c *LoadingCache[K, V]) SetLoadObserver(fn func(key K, duration time.Duration, err error)) {
+	c.mu.Lock()
+	c.observer = fn
+	c.mu.Unlock()
+}
+
+// SetFallbackLoader sets fn to be tried for Get when the loader returns an
+// error, such as a replica or a stale store. A value from fn is cached and
+// returned as if loaded, except that with a positive ttl it is only kept
+// for ttl, or in a stale-while-revalidate cache is due for refresh after
+// ttl, so the primary source is tried again soon. If fn fails too, the
+// loader's error is returned. A nil fn removes the fallback. The bulk
+// loader has no fallback.
+func (c *LoadingCache[K, V]) SetFallbackLoader(fn func(key K) (V, error), ttl time.Duration) {
+	c.mu.Lock()
+	c.fallback = fn
+	c.fallbackTTL = ttl
+	c.mu.Unlock()
+}
+
+// loadValue calls the loader, and the fallback loader if that fails. ttl
+// is how long a fallback value stays fresh, or 0 for a normal value.
+func (c *LoadingCache[K, V]) loadValue(key K) (value V, ttl time.Duration, err error) {
+	value, err = c.callLoader(key)
+	if err == nil {
+		return value, 0, nil
+	}
+	c.mu.Lock()
+	fallback, ttl := c.fallback, c.fallbackTTL
+	c.mu.Unlock()
+	if fallback != nil {
+		if v, ferr := fallback(key); ferr == nil {
+			return v, ttl, nil
+		}
+	}
+	return value, 0, err
+}
+
+// callLoader calls the loader for key if the circuit breaker allows it,
+// and reports it to the observer and the breaker
+func (c *LoadingCache[K, V]) callLoader(key K) (value V, err error) {
+	if !c.allowLoad() {
+		return value, ErrCircuitOpen
+	}
+	start := time.Now()
+	value, err = c.loader(key)
+	c.mu.Lock()
+	observer := c.observer
+	c.mu.Unlock()
+	if observer != nil {
+		observer(key, time.Since(start), err)
+	}
+	c.recordLoad(err)
+	return value, err
+}
+
+// Add adds a value to the cache without calling the loader.  Returns true
+// if an eviction occurred.
+func (c *LoadingCache[K, V]) Add(key K, value V) bool {
+	if c.revalidate {
+		return c.lru.Add(key, loaded[V]{value: value, at: time.Now()})
+	}
+	return c.lru.Add(key, value)
+}
+
+// Peek returns the key value without loading it or updating the "recently
+// used"-ness of the key. A stale value is returned as is.
+func (c *LoadingCache[K, V]) Peek(key K) (value V, ok bool) {
+	v, ok := c.lru.Peek(key)
+	if !ok {
+		return value, false
+	}
+	if c.revalidate {
+		return v.(loaded[V]).value, true
+	}
+	value, _ = v.(V)
+	return value, true
+}
+
+// Contains checks if a key is in the cache, without loading it or
+// updating the recent-ness.
+func (c *LoadingCache[K, V]) Contains(key K) bool {
+	return c.lru.Contains(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *LoadingCache[K, V]) Remove(key K) {
+	c.lru.Remove(key)
+}
+
+// Len returns the number of items in the cache.
+func (c *LoadingCache[K, V]) Len() int {
+	return c.lru.Len()
+}
+
+// Purge is used to completely clear the cache
+func (c *LoadingCache[K, V]) Purge() {
+	c.lru.Purge()
+}
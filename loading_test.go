@@ -0,0 +1,423 @@
+package lru
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadingCache(t *testing.T) {
+	loads := 0
+	l, err := NewLoading(2, func(k int) (string, error) {
+		loads++
+		if k < 0 {
+			return "", errors.New("negative")
+		}
+		return strconv.Itoa(k), nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if v, err := l.Get(1); err != nil || v != "1" {
+		t.Fatalf("bad value: %v %v", v, err)
+	}
+	if v, _ := l.Get(1); v != "1" || loads != 1 {
+		t.Errorf("hit should not load: %d loads", loads)
+	}
+	if _, err := l.Get(-1); err == nil || l.Contains(-1) {
+		t.Errorf("errors should be returned and not cached")
+	}
+
+	l.Add(2, "two")
+	if v, ok := l.Peek(2); !ok || v != "two" {
+		t.Errorf("bad value: %v", v)
+	}
+	l.Remove(2)
+	if _, ok := l.Peek(2); ok || l.Len() != 1 {
+		t.Errorf("2 should be removed")
+	}
+	l.Purge()
+	if l.Len() != 0 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+func TestLoadingCache_SingleFlight(t *testing.T) {
+	var loads int32
+	l, err := NewLoading(4, func(k string) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return len(k), nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := l.Get("four"); err != nil || v != 4 {
+				t.Errorf("bad value: %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("concurrent misses should share a load: %d", n)
+	}
+}
+
+func TestLoadingCache_StaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	gate := make(chan struct{})
+	l, err := NewLoadingWithStaleWhileRevalidate(4, func(k string) (int, error) {
+		if atomic.AddInt32(&loads, 1) > 1 {
+			<-gate
+		}
+		return int(atomic.LoadInt32(&loads)), nil
+	}, 20*time.Millisecond, 60*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if v, err := l.Get("k"); err != nil || v != 1 {
+		t.Fatalf("bad value: %v %v", v, err)
+	}
+	if v, _ := l.Get("k"); v != 1 || atomic.LoadInt32(&loads) != 1 {
+		t.Errorf("fresh value should not load")
+	}
+
+	// Stale but within MaxStale: served at once while a refresh starts
+	time.Sleep(30 * time.Millisecond)
+	start := time.Now()
+	if v, _ := l.Get("k"); v != 1 || time.Since(start) > 10*time.Millisecond {
+		t.Errorf("stale value should be served without waiting: %v", v)
+	}
+	l.Get("k")
+
+	// MaxStale elapses while the refresh is still running, so Get
+	// blocks and joins it rather than serving the old value
+	time.Sleep(60 * time.Millisecond)
+	got := make(chan int)
+	go func() {
+		v, _ := l.Get("k")
+		got <- v
+	}()
+	select {
+	case v := <-got:
+		t.Fatalf("too stale to serve, got %v", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(gate)
+	if v := <-got; v != 2 {
+		t.Errorf("should get the refreshed value: %v", v)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("refresh should be shared: %d loads", n)
+	}
+	if v, ok := l.Peek("k"); !ok || v != 2 {
+		t.Errorf("bad value: %v", v)
+	}
+}
+
+func TestLoadingCache_StaleRefreshError(t *testing.T) {
+	fail := false
+	l, err := NewLoadingWithStaleWhileRevalidate(4, func(k int) (int, error) {
+		if fail {
+			return 0, errors.New("backend down")
+		}
+		return k, nil
+	}, 10*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 100)
+	fail = true
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if v, err := l.Get(1); err != nil || v != 100 {
+			t.Errorf("stale value should survive a failed refresh: %v %v", v, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := l.Get(2); err == nil {
+		t.Errorf("a miss should return the load error")
+	}
+}
+
+func TestLoadingCache_LoadObserver(t *testing.T) {
+	for _, revalidate := range []bool{false, true} {
+		loader := func(k int) (int, error) {
+			time.Sleep(time.Millisecond)
+			if k < 0 {
+				return 0, errors.New("negative")
+			}
+			return k, nil
+		}
+		var l *LoadingCache[int, int]
+		var err error
+		if revalidate {
+			l, err = NewLoadingWithStaleWhileRevalidate(2, loader, time.Hour, time.Hour)
+		} else {
+			l, err = NewLoading(2, loader)
+		}
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		var keys []int
+		var errs []error
+		l.SetLoadObserver(func(k int, d time.Duration, err error) {
+			if d < time.Millisecond {
+				t.Errorf("bad duration: %v", d)
+			}
+			keys = append(keys, k)
+			errs = append(errs, err)
+		})
+		l.Get(1)
+		l.Get(1)
+		l.Get(-1)
+		if len(keys) != 2 || keys[0] != 1 || keys[1] != -1 {
+			t.Fatalf("revalidate %v: bad observed keys: %v", revalidate, keys)
+		}
+		if errs[0] != nil || errs[1] == nil {
+			t.Errorf("revalidate %v: bad observed errors: %v", revalidate, errs)
+		}
+
+		l.SetLoadObserver(nil)
+		l.Get(2)
+		if len(keys) != 2 {
+			t.Errorf("removed observer should not be called")
+		}
+	}
+}
+
+// Test that a nil value of an interface type is returned as the zero V
+func TestLoadingCache_NilInterfaceValue(t *testing.T) {
+	l, err := NewLoading(2, func(k int) (error, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if v, err := l.Get(1); err != nil || v != nil {
+		t.Fatalf("bad value: %v %v", v, err)
+	}
+	if v, err := l.Get(1); err != nil || v != nil {
+		t.Fatalf("bad cached value: %v %v", v, err)
+	}
+	if v, ok := l.Peek(1); !ok || v != nil {
+		t.Errorf("bad peek: %v %v", v, ok)
+	}
+}
+
+func TestLoadingCache_GetAll(t *testing.T) {
+	var batches [][]int
+	l, err := NewLoading(8, func(k int) (string, error) {
+		return strconv.Itoa(k), nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetBulkLoader(func(keys []int) (map[int]string, error) {
+		batches = append(batches, keys)
+		values := make(map[int]string)
+		for _, k := range keys {
+			if k >= 0 {
+				values[k] = strconv.Itoa(k)
+			}
+		}
+		return values, nil
+	})
+
+	l.Add(1, "one")
+	values, err := l.GetAll([]int{1, 2, 3, 2, -1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("misses should be loaded in one batch: %v", batches)
+	}
+	if len(values) != 3 || values[1] != "one" || values[2] != "2" || values[3] != "3" {
+		t.Errorf("bad values: %v", values)
+	}
+	if !l.Contains(2) || !l.Contains(3) || l.Contains(-1) {
+		t.Errorf("loaded values should be cached")
+	}
+
+	l.SetBulkLoader(func(keys []int) (map[int]string, error) {
+		return nil, errors.New("backend down")
+	})
+	if _, err := l.GetAll([]int{1, 4}); err == nil {
+		t.Errorf("should return the bulk loader error")
+	}
+	if l.Contains(4) {
+		t.Errorf("failed load should not be cached")
+	}
+
+	// Without a bulk loader, misses go through the loader
+	l.SetBulkLoader(nil)
+	if values, err := l.GetAll([]int{5}); err != nil || values[5] != "5" {
+		t.Errorf("bad values: %v %v", values, err)
+	}
+}
+
+// Test that a panicking bulk loader does not leave its keys claimed
+func TestLoadingCache_GetAllPanic(t *testing.T) {
+	l, err := NewLoading(8, func(k int) (int, error) {
+		return k, nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetBulkLoader(func(keys []int) (map[int]int, error) {
+		panic("bulk loader bug")
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("the panic should reach the caller")
+			}
+		}()
+		l.GetAll([]int{1, 2})
+	}()
+
+	l.SetBulkLoader(func(keys []int) (map[int]int, error) {
+		values := make(map[int]int)
+		for _, k := range keys {
+			values[k] = k
+		}
+		return values, nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if values, err := l.GetAll([]int{1, 2}); err != nil || len(values) != 2 {
+			t.Errorf("bad values: %v %v", values, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("GetAll blocked on keys claimed by the panicked load")
+	}
+}
+
+// Test that overlapping GetAll calls share the loads of common keys
+func TestLoadingCache_GetAllSingleFlight(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	l, err := NewLoading(8, func(k int) (int, error) {
+		return k, nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetBulkLoader(func(keys []int) (map[int]int, error) {
+		atomic.AddInt32(&loads, int32(len(keys)))
+		<-release
+		values := make(map[int]int)
+		for _, k := range keys {
+			values[k] = k
+		}
+		return values, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if values, err := l.GetAll([]int{1, 2}); err != nil || len(values) != 2 {
+				t.Errorf("bad values: %v %v", values, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("each key should be loaded once: %d", n)
+	}
+}
+
+func TestLoadingCache_FallbackLoader(t *testing.T) {
+	primary := errors.New("primary down")
+	l, err := NewLoading(4, func(k int) (string, error) {
+		if k%2 == 0 {
+			return "", primary
+		}
+		return "primary", nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetFallbackLoader(func(k int) (string, error) {
+		if k < 0 {
+			return "", errors.New("replica down")
+		}
+		return "replica", nil
+	}, 20*time.Millisecond)
+
+	if v, err := l.Get(1); err != nil || v != "primary" {
+		t.Errorf("bad value: %v %v", v, err)
+	}
+	if v, err := l.Get(2); err != nil || v != "replica" {
+		t.Errorf("bad fallback value: %v %v", v, err)
+	}
+	if _, err := l.Get(-2); err != primary {
+		t.Errorf("should return the primary error: %v", err)
+	}
+
+	// The fallback value expires after its ttl, the loaded one does not
+	time.Sleep(30 * time.Millisecond)
+	if l.Contains(2) || !l.Contains(1) {
+		t.Errorf("fallback value should expire")
+	}
+}
+
+func TestLoadingCache_FallbackRevalidate(t *testing.T) {
+	var down int32 = 1
+	l, err := NewLoadingWithStaleWhileRevalidate(4, func(k int) (string, error) {
+		if atomic.LoadInt32(&down) == 1 {
+			return "", errors.New("primary down")
+		}
+		return "primary", nil
+	}, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetFallbackLoader(func(k int) (string, error) {
+		return "replica", nil
+	}, 10*time.Millisecond)
+
+	if v, err := l.Get(1); err != nil || v != "replica" {
+		t.Fatalf("bad fallback value: %v %v", v, err)
+	}
+
+	// Past the fallback ttl the stale value is served while the primary
+	// source refreshes it
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := l.Get(1); v != "replica" {
+		t.Errorf("stale value should be served: %v", v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := l.Peek(1); v == "primary" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value should be refreshed from the primary source")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
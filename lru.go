@@ -4,17 +4,94 @@
 package lru
 
 import (
+	"errors"
+	"io"
+	"reflect"
 	"sync"
+	"time"
+	"unsafe"
 
 	"github.com/caser789/go-lru/simplelru"
 )
 
+// ApproxEntryOverhead is the estimated number of bytes of bookkeeping the
+// cache keeps per entry, covering its list element and map slot.
+const ApproxEntryOverhead = 128
+
+// ErrNilKey is returned by TryAdd when given a nil key.
+var ErrNilKey = errors.New("nil key")
+
+// ErrBatchTooLarge is returned by AddManyMode with OverflowReject when the
+// batch has more pairs than the cache can hold.
+var ErrBatchTooLarge = errors.New("batch larger than cache size")
+
+// OverflowMode selects what AddManyMode does with a batch larger than the
+// cache.
+type OverflowMode int
+
+const (
+	// OverflowKeepLast adds every pair, so only the last ones remain, as
+	// AddManyReturningEvicted does
+	OverflowKeepLast OverflowMode = iota
+	// OverflowKeepFirst adds only as many pairs from the start of the
+	// batch as the cache can hold
+	OverflowKeepFirst
+	// OverflowReject adds nothing and returns ErrBatchTooLarge
+	OverflowReject
+)
+
+// errLoadPanicked is handed to GetOrLoad callers waiting on a load that
+// panicked
+var errLoadPanicked = errors.New("load panicked")
+
+// Loading is the value Get and Peek return for a key whose placeholder,
+// added with AddPlaceholder, has not been fulfilled yet.
+var Loading interface{} = loading{}
+
+// loading is the type of Loading; being unexported, no other value can
+// compare equal to it
+type loading struct{}
+
+// KV is a key-value pair as held by the cache.
+type KV = simplelru.KV
+
 // Cache is a thread-safe fixed size LRU cache.
 type Cache struct {
-	lru  simplelru.LRUCache
+	lru         *simplelru.LRU
+	softCapped  bool
+	onEvicted   func(key interface{}, value interface{})
+	copy        func(value interface{}) interface{}
+	transform   func(value interface{}) interface{}
+	makeDefault func(key interface{}) interface{}
+	loads       map[interface{}]*loadCall
+	recording   bool
+	evicted     []KV
+
+	onCapacity func(full bool)
+	wasFull    bool
+
+	onRemove       func(key interface{})
+	suppressRemove bool
+
+	stats    Stats
+	keysSeen *keySketch
+	recent   *evictionRing
+	lookups  *lookupRing
+	trace    func(op string, key interface{})
+	members  *sync.Map
+
+	evictQueue *asyncEvictQueue
+
 	lock sync.RWMutex
 }
 
+// loadCall is an in-flight GetOrLoad that concurrent callers wait on
+type loadCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
 // New creates an LRU of the given size
 func New(size int) (*Cache, error) {
 	return NewWithEvict(size, nil)
@@ -23,35 +100,524 @@ func New(size int) (*Cache, error) {
 // NewWithEvict constructs a fixed size cache with the given eviction
 // callback.
 func NewWithEvict(size int, onEvicted func(key interface{}, value interface{})) (*Cache, error) {
-	lru, err := simplelru.NewLRU(size, simplelru.EvictCallback(onEvicted))
+	c := &Cache{
+		onEvicted: onEvicted,
+	}
+	lru, err := simplelru.NewLRU(size, c.onEvict)
 	if err != nil {
 		return nil, err
 	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewFromMap creates an LRU of the given size holding the entries of m.
+// Map iteration order is random, so the recency order of the entries is
+// unspecified, and only size of them are kept if m is larger.
+func NewFromMap(size int, m map[interface{}]interface{}) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		c.lru.Add(k, v)
+	}
+	return c, nil
+}
+
+// NewWithEvictBatch constructs a fixed size cache that evicts in batches:
+// the cache may hold up to batch entries more than size, and when an Add
+// pushes it past that high-water mark of size+batch, the oldest entries
+// are dropped until only size remain.
+func NewWithEvictBatch(size, batch int) (*Cache, error) {
+	c := &Cache{}
+	lru, err := simplelru.NewLRUWithEvictBatch(size, batch, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithCanEvict constructs a fixed size cache whose evictions can be
+// vetoed by canEvict. When the oldest entry is refused, the next-oldest one
+// is tried instead; if every entry is refused the cache grows beyond size
+// until one becomes evictable.
+func NewWithCanEvict(size int, onEvicted func(key interface{}, value interface{}), canEvict func(key interface{}, value interface{}) bool) (*Cache, error) {
 	c := &Cache{
-		lru: lru,
+		onEvicted: onEvicted,
+	}
+	lru, err := simplelru.NewLRUWithCanEvict(size, c.onEvict, simplelru.CanEvictFunc(canEvict))
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithPromoteThreshold constructs a fixed size cache in which Get only
+// moves an entry to the front after it has been read k times since its last
+// promotion. Below the threshold Get returns the value but leaves the entry
+// in place, which keeps one-off scans from pushing out the hot entries.
+func NewWithPromoteThreshold(size, k int) (*Cache, error) {
+	c := &Cache{}
+	lru, err := simplelru.NewLRUWithPromoteThreshold(size, k, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithMinResidency constructs a fixed size cache in which entries are
+// protected from eviction until they have been cached for minDwell. When
+// the oldest entries are too young, older-but-eligible ones are evicted
+// instead; if every entry is too young the cache grows beyond size until
+// one ages enough to be evicted.
+func NewWithMinResidency(size int, minDwell time.Duration) (*Cache, error) {
+	c := &Cache{}
+	lru, err := simplelru.NewLRUWithMinResidency(size, minDwell, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithFreshnessPromotion constructs a fixed size cache in which Get
+// only marks an entry as recently used if it has at least minRemaining
+// before it expires. Reads of an entry about to expire still return it but
+// leave it in place, biasing eviction towards entries that are nearly dead.
+func NewWithFreshnessPromotion(size int, minRemaining time.Duration) (*Cache, error) {
+	c := &Cache{}
+	lru, err := simplelru.NewLRUWithFreshnessPromotion(size, minRemaining, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithOnAdd constructs a fixed size cache that calls onAdd whenever an
+// entry is inserted or updated by any of the add methods, with isUpdate
+// telling a refresh of an existing key from a new one. Like the eviction
+// callback, onAdd runs under the cache's lock and must not call back into
+// the cache.
+func NewWithOnAdd(size int, onAdd func(key, value interface{}, isUpdate bool)) (*Cache, error) {
+	c := &Cache{}
+	lru, err := simplelru.NewLRUWithOnAdd(size, c.onEvict, simplelru.AddCallback(onAdd))
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// NewWithTrace constructs a fixed size cache that reports its access
+// sequence to sink for offline replay: "add" for each Add, "remove" for
+// each Remove, and "get" for each lookup counted in Stats. The sink is
+// called under the cache's lock, so the trace is in the order operations
+// took effect; it must be cheap and must not call back into the cache.
+func NewWithTrace(size int, sink func(op string, key interface{})) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
 	}
+	c.trace = sink
 	return c, nil
 }
 
+// NewWithDirtyTracking constructs a fixed size cache that records which
+// keys were added or updated since the last ClearDirty, for write-behind
+// layers that periodically flush modified entries.
+func NewWithDirtyTracking(size int) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.lru.TrackDirty()
+	return c, nil
+}
+
+// NewWithAccessTracking constructs a fixed size cache that records when
+// each entry was last added, updated or read with Get, for EvictOlderThan.
+// Every Get pays for a clock read.
+func NewWithAccessTracking(size int) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.lru.TrackAccess()
+	return c, nil
+}
+
+// NewSoftCapped constructs an unbounded cache that only shrinks when asked
+// to: Add never evicts, and Trim evicts the oldest entries until at most
+// softCap remain. It suits bursty workloads that briefly need more room
+// than their steady state.
+func NewSoftCapped(softCap int) (*Cache, error) {
+	c, err := New(softCap)
+	if err != nil {
+		return nil, err
+	}
+	c.softCapped = true
+	c.lru.PauseEviction()
+	return c, nil
+}
+
+// NewWithCopyFunc constructs a fixed size cache that stores and hands out
+// copies of its values, made with copy. Add stores copy(value), and Get,
+// Peek and the other lookups return a fresh copy, so callers can never
+// mutate the cached value. Every store and lookup pays for a copy.
+func NewWithCopyFunc(size int, copy func(value interface{}) interface{}) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.copy = copy
+	return c, nil
+}
+
+// NewWithTransform constructs a fixed size cache that passes every value
+// through transform before storing it, for example to intern strings or
+// share common sub-objects. Lookups return the transformed value. The
+// transform runs with the cache lock held, so it should be cheap.
+func NewWithTransform(size int, transform func(value interface{}) interface{}) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.transform = transform
+	return c, nil
+}
+
+// NewWithDefault constructs a fixed size cache where Get on a missing key
+// stores makeDefault(key) and returns it with ok true, as for counters and
+// other accumulators. From then on it is a normal entry. Note that such a
+// Get mutates the cache and may evict; it still counts as a miss. Peek,
+// Contains and the other lookups do not create entries.
+func NewWithDefault(size int, makeDefault func(key interface{}) interface{}) (*Cache, error) {
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.makeDefault = makeDefault
+	return c, nil
+}
+
+// onEvict is the eviction callback registered with the underlying LRU. It
+// records evictions for AddManyReturningEvicted and forwards them to the
+// user's callback.
+func (c *Cache) onEvict(key interface{}, value interface{}) {
+	if c.members != nil {
+		c.members.Delete(key)
+	}
+	if c.recording {
+		c.evicted = append(c.evicted, KV{Key: key, Value: value})
+	}
+	if c.onRemove != nil && !c.suppressRemove {
+		c.onRemove(key)
+	}
+	if c.onEvicted != nil {
+		if c.evictQueue != nil && c.evictQueue.push(KV{Key: key, Value: value}) {
+			return
+		}
+		c.onEvicted(key, value)
+	}
+}
+
+// OnRemove registers fn to be called with the key of every entry that
+// leaves the cache, whether removed, evicted or purged, so removals can be
+// replicated to peers. Removals applied with ApplyRemove are not reported.
+// Like the eviction callback, fn runs under the cache's lock and must not
+// call back into the cache.
+func (c *Cache) OnRemove(fn func(key interface{})) {
+	c.lock.Lock()
+	c.onRemove = fn
+	c.unlock()
+}
+
+// ApplyRemove removes the provided key from the cache without reporting it
+// to the OnRemove callback, for applying a removal received from a peer
+// without echoing it back.
+func (c *Cache) ApplyRemove(key interface{}) {
+	c.lock.Lock()
+	c.suppressRemove = true
+	c.lru.Remove(key)
+	c.suppressRemove = false
+	c.unlock()
+}
+
+// OnCapacityChange registers fn to be called whenever the cache becomes
+// full or stops being full. fn runs after the cache's lock is released, so
+// it may use the cache, but notifications from concurrent writers may be
+// delivered out of order.
+func (c *Cache) OnCapacityChange(fn func(full bool)) {
+	c.lock.Lock()
+	c.onCapacity = fn
+	c.wasFull = c.full()
+	c.lock.Unlock()
+}
+
+// unlock releases the write lock, waits for an asynchronous eviction queue
+// to drain back within its size, and then notifies the OnCapacityChange
+// callback if the cache crossed its size since the last notification.
+func (c *Cache) unlock() {
+	fn := c.onCapacity
+	full := fn != nil && c.full()
+	changed := fn != nil && full != c.wasFull
+	if changed {
+		c.wasFull = full
+	}
+	q := c.evictQueue
+	c.lock.Unlock()
+	if q != nil {
+		q.wait()
+	}
+	if changed {
+		fn(full)
+	}
+}
+
 // Purge is used to completely clear the cache
 func (c *Cache) Purge() {
 	c.lock.Lock()
 	c.lru.Purge()
-	c.lock.Unlock()
+	c.unlock()
+}
+
+// PurgeOrdered clears the cache like Purge, but runs the eviction callback
+// for each entry in strict order from least to most recently used, for
+// resources that must be torn down in LRU order.
+func (c *Cache) PurgeOrdered() {
+	c.lock.Lock()
+	c.lru.PurgeOrdered()
+	c.unlock()
+}
+
+// FlushTo encodes every entry to w, from oldest to newest, and then purges
+// the cache. Returns the number of entries flushed. If encode fails the
+// cache is left untouched and the error is returned along with the number
+// of entries encoded before it.
+func (c *Cache) FlushTo(w io.Writer, encode func(w io.Writer, kv KV) error) (int, error) {
+	c.lock.Lock()
+	defer c.unlock()
+
+	n := 0
+	for _, k := range c.lru.Keys() {
+		v, ok := c.lru.Peek(k)
+		if !ok {
+			continue
+		}
+		if err := encode(w, KV{Key: k, Value: v}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	c.lru.Purge()
+	return n, nil
 }
 
 // Add adds a value to the cache.  Returns true if an eviction occurred.
 func (c *Cache) Add(key, value interface{}) bool {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	return c.lru.Add(key, value)
+	defer c.unlock()
+	if c.trace != nil {
+		c.trace("add", key)
+	}
+	return c.lru.Add(key, c.storeValue(value))
+}
+
+// TryAdd adds a value to the cache like Add, but returns ErrNilKey instead
+// of storing a value under a nil key. Returns true if an eviction occurred.
+func (c *Cache) TryAdd(key, value interface{}) (bool, error) {
+	if key == nil {
+		return false, ErrNilKey
+	}
+	return c.Add(key, value), nil
+}
+
+// RejectNilKeys makes every way of adding to the cache ignore nil keys
+// from now on, since a nil key is almost always a bug and all nil keys
+// would otherwise overwrite each other.
+func (c *Cache) RejectNilKeys() {
+	c.lock.Lock()
+	c.lru.RejectNilKeys()
+	c.unlock()
+}
+
+// AddManyReturningEvicted adds all pairs to the cache under a single lock
+// and returns every entry evicted while doing so, in eviction order. Pairs
+// are added in slice order, so the first pair is the oldest of the batch
+// and is evicted before the others.
+func (c *Cache) AddManyReturningEvicted(pairs []KV) []KV {
+	c.lock.Lock()
+	defer c.unlock()
+
+	c.recording = true
+	for _, kv := range pairs {
+		c.lru.Add(kv.Key, c.storeValue(kv.Value))
+	}
+	evicted := c.evicted
+	c.recording = false
+	c.evicted = nil
+	return evicted
+}
+
+// AddManyMode adds all pairs to the cache under a single lock, in slice
+// order, with mode deciding what happens when there are more pairs than
+// the cache size. Only OverflowReject returns an error.
+func (c *Cache) AddManyMode(pairs []KV, mode OverflowMode) error {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if size := c.lru.Size(); len(pairs) > size {
+		switch mode {
+		case OverflowReject:
+			return ErrBatchTooLarge
+		case OverflowKeepFirst:
+			pairs = pairs[:size]
+		}
+	}
+	for _, kv := range pairs {
+		c.lru.Add(kv.Key, c.storeValue(kv.Value))
+	}
+	return nil
+}
+
+// AddExpireAt adds a value to the cache that expires at the given
+// deadline, after which Get, Peek and Contains treat it as missing. A
+// deadline in the past makes the entry expired immediately. Expired entries
+// still count towards Len until they are looked up or evicted. Returns true
+// if an eviction occurred.
+func (c *Cache) AddExpireAt(key, value interface{}, deadline time.Time) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.AddExpireAt(key, c.storeValue(value), deadline)
+}
+
+// AddWithPriority adds a value to the cache in a priority tier. Eviction
+// prefers entries in lower tiers, even more recently used ones, and is LRU
+// within a tier; Get promotes an entry only within its tier. A new key
+// alone in a tier below every other entry is evicted itself when the
+// cache is full. Entries added by Add are in tier 0. Returns true if an
+// eviction occurred.
+func (c *Cache) AddWithPriority(key, value interface{}, priority int) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.AddWithPriority(key, c.storeValue(value), priority)
+}
+
+// AddCold adds a value to the cache as the least recently used entry, so
+// it is evicted first unless it is accessed. Returns true if an eviction
+// occurred.
+func (c *Cache) AddCold(key, value interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.AddCold(key, c.storeValue(value))
+}
+
+// Merge adds all of other's entries to c, from other's oldest to newest,
+// evicting from c as needed. On a key conflict other's value wins and the
+// entry becomes the most recently used. other is snapshotted first, so the
+// two caches are never locked at the same time.
+func (c *Cache) Merge(other *Cache) {
+	other.lock.RLock()
+	keys := other.lru.Keys()
+	kvs := make([]KV, 0, len(keys))
+	for _, k := range keys {
+		if v, ok := other.lru.Peek(k); ok {
+			kvs = append(kvs, KV{Key: k, Value: v})
+		}
+	}
+	other.lock.RUnlock()
+
+	c.lock.Lock()
+	defer c.unlock()
+	for _, kv := range kvs {
+		c.lru.Add(kv.Key, c.storeValue(kv.Value))
+	}
 }
 
 // Get looks up a key's value from the cache.
 func (c *Cache) Get(key interface{}) (interface{}, bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	return c.lru.Get(key)
+	defer c.unlock()
+	value, ok := c.get(key)
+	if !ok && c.makeDefault != nil {
+		value = c.storeValue(c.makeDefault(key))
+		if c.trace != nil {
+			c.trace("add", key)
+		}
+		c.lru.Add(key, value)
+		ok = true
+	}
+	if ok {
+		value = c.copyValue(value)
+	}
+	return value, ok
+}
+
+// Touch marks a key as recently used like Get, without copying or
+// returning its value, and reports whether it was in the cache. It suits
+// heartbeats that only keep an entry alive. Touch is not counted as a
+// lookup, and an entry's expiry deadline, if any, is left as it was.
+func (c *Cache) Touch(key interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	_, ok := c.lru.Get(key)
+	return ok
+}
+
+// GetForUse looks up a key's value like Get and keeps the entry from being
+// evicted until the returned release is called, so a borrowed value such as
+// a pooled buffer is not evicted while still in use. Release may be called
+// more than once. While entries are held the cache may grow past its size,
+// shrinking back on later adds once they are released.
+func (c *Cache) GetForUse(key interface{}) (value interface{}, release func(), ok bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	value, unpin, ok := c.lru.Acquire(key)
+	c.countLookup(key, ok)
+	if !ok {
+		return nil, nil, false
+	}
+	return c.copyValue(value), func() {
+		c.lock.Lock()
+		unpin()
+		c.unlock()
+	}, true
+}
+
+// Result is the outcome of looking up one key in a batch.
+type Result struct {
+	Value interface{}
+	Found bool
+}
+
+// GetSlice looks up every key under a single lock, returning a result per
+// key in the same order, so result i describes keys[i]. Hits are promoted
+// and counted as by Get.
+func (c *Cache) GetSlice(keys []interface{}) []Result {
+	c.lock.Lock()
+	defer c.unlock()
+	results := make([]Result, len(keys))
+	for i, k := range keys {
+		if value, ok := c.get(k); ok {
+			results[i] = Result{Value: c.copyValue(value), Found: true}
+		}
+	}
+	return results
+}
+
+// PinFor protects key from eviction for d, after which it becomes a normal
+// eviction candidate again, so a pin cannot be leaked by a caller that
+// never releases it. Pinning again replaces the deadline. Returns whether
+// the key was in the cache.
+func (c *Cache) PinFor(key interface{}, d time.Duration) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.PinFor(key, d)
 }
 
 // Check if a key is in the cache, without updating the recent-ness
@@ -62,12 +628,94 @@ func (c *Cache) Contains(key interface{}) bool {
 	return c.lru.Contains(key)
 }
 
+// ContainsAll reports whether every one of keys is in the cache, checked
+// under a single lock without updating recent-ness. It is true for no keys.
+func (c *Cache) ContainsAll(keys []interface{}) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, k := range keys {
+		if !c.lru.Contains(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny reports whether at least one of keys is in the cache, checked
+// under a single lock without updating recent-ness. It is false for no keys.
+func (c *Cache) ContainsAny(keys []interface{}) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, k := range keys {
+		if c.lru.Contains(k) {
+			return true
+		}
+	}
+	return false
+}
+
 // Returns the key value (or undefined if not found) without updating
 // the "recently used"-ness of the key.
 func (c *Cache) Peek(key interface{}) (interface{}, bool) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return c.lru.Peek(key)
+	value, ok := c.lru.Peek(key)
+	if ok {
+		value = c.copyValue(value)
+	}
+	return value, ok
+}
+
+// GetOrLoad looks up a key's value from the cache, calling load to fetch
+// it on a miss. A successfully loaded value is added to the cache; errors
+// are returned but not cached. Concurrent calls for the same missing key
+// share a single load.
+func (c *Cache) GetOrLoad(key interface{}, load func() (interface{}, error)) (value interface{}, err error) {
+	c.lock.Lock()
+	if value, ok := c.get(key); ok {
+		c.unlock()
+		return c.copyValue(value), nil
+	}
+	if call, ok := c.loads[key]; ok {
+		c.unlock()
+		call.wg.Wait()
+		if call.err != nil {
+			return nil, call.err
+		}
+		return c.copyValue(call.value), nil
+	}
+	call := &loadCall{}
+	call.wg.Add(1)
+	if c.loads == nil {
+		c.loads = make(map[interface{}]*loadCall)
+	}
+	c.loads[key] = call
+	c.unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.loads, key)
+		if call.err == nil {
+			call.value = c.storeValue(call.value)
+			c.lru.Add(key, call.value)
+			value = c.copyValue(call.value)
+		}
+		c.unlock()
+		call.wg.Done()
+	}()
+
+	call.err = errLoadPanicked
+	call.value, call.err = load()
+	return nil, call.err
+}
+
+// Rank returns how close key is to being evicted, where 0 is the most
+// recently used entry and Len()-1 the next to go, without updating its
+// recent-ness. It is linear in the rank and meant for diagnostics.
+func (c *Cache) Rank(key interface{}) (rank int, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.Rank(key)
 }
 
 // ContainsOrAdd checks if a key is in the cache  without updating the
@@ -75,37 +723,303 @@ func (c *Cache) Peek(key interface{}) (interface{}, bool) {
 // Returns whether found and whether an eviction occurred.
 func (c *Cache) ContainsOrAdd(key, value interface{}) (ok, evict bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	defer c.unlock()
 
 	if c.lru.Contains(key) {
 		return true, false
 	}
 
-	evict = c.lru.Add(key, value)
+	evict = c.lru.Add(key, c.storeValue(value))
 	return false, evict
 }
 
+// CompareAndSwap replaces the value of key with new, and marks it as
+// recently used, only if its current value equals old. Values are compared
+// with ==, and values of uncomparable types, such as slices or maps, never
+// match. A cache built with NewWithCopyFunc holds its own copy of every
+// value, so pointers and other references handed out never match either;
+// use GetVersioned and CompareVersionAndSwap there instead. Returns
+// whether the swap happened.
+func (c *Cache) CompareAndSwap(key, old, new interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+
+	current, ok := c.lru.Peek(key)
+	if !ok || !canCompare(current) || !canCompare(old) || current != old {
+		return false
+	}
+	c.lru.Add(key, c.storeValue(new))
+	return true
+}
+
+// canCompare reports whether v can be compared with == without panicking
+func canCompare(v interface{}) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+// GetVersioned looks up a key's value like Get, together with the version
+// of that value. The version changes on every write of the key, so it can
+// be passed to CompareVersionAndSwap to commit an update computed from the
+// value only if nobody else wrote it meanwhile.
+func (c *Cache) GetVersioned(key interface{}) (value interface{}, version uint64, ok bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	value, ok = c.get(key)
+	if !ok {
+		return nil, 0, false
+	}
+	version, _ = c.lru.Version(key)
+	return c.copyValue(value), version, true
+}
+
+// CompareVersionAndSwap replaces the value of key with new, and marks it
+// as recently used, only if its value still has the version expected, as
+// returned by GetVersioned. Returns whether the swap happened; on false
+// the caller should read again and retry.
+func (c *Cache) CompareVersionAndSwap(key interface{}, expectedVersion uint64, new interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if version, ok := c.lru.Version(key); !ok || version != expectedVersion {
+		return false
+	}
+	c.lru.Add(key, c.storeValue(new))
+	return true
+}
+
+// AddPlaceholder reserves key for a value that is still being loaded,
+// unless the key is already cached. Until FulfillPlaceholder is called,
+// lookups of the key return Loading, letting concurrent callers see that a
+// load is in progress instead of starting their own. Returns whether the
+// key already existed, in which case nothing is changed.
+func (c *Cache) AddPlaceholder(key interface{}) (alreadyExists bool) {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if c.lru.Contains(key) {
+		return true
+	}
+	c.lru.Add(key, Loading)
+	return false
+}
+
+// FulfillPlaceholder replaces the placeholder for key with value, marking
+// it as recently used. Returns false, and changes nothing, if key no longer
+// holds a placeholder because it was evicted, removed or overwritten.
+func (c *Cache) FulfillPlaceholder(key, value interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if current, ok := c.lru.Peek(key); !ok || current != Loading {
+		return false
+	}
+	c.lru.Add(key, c.storeValue(value))
+	return true
+}
+
+// Swap stores value under key, marking it as recently used, and returns
+// the value it replaced. If the key was not cached it is inserted and
+// (nil, false) is returned.
+func (c *Cache) Swap(key, value interface{}) (old interface{}, existed bool) {
+	c.lock.Lock()
+	defer c.unlock()
+
+	old, existed = c.lru.Peek(key)
+	c.lru.Add(key, c.storeValue(value))
+	return old, existed
+}
+
+// Update applies fn to the current value of key under the lock, for a safe
+// read-modify-write. If fn returns keep false the entry is removed,
+// otherwise its value is replaced without changing its recent-ness.
+// Returns whether the key existed; fn is only called if it did. Like the
+// eviction callback, fn must not call back into the cache.
+func (c *Cache) Update(key interface{}, fn func(old interface{}) (new interface{}, keep bool)) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.Update(key, func(old interface{}) (interface{}, bool) {
+		value, keep := fn(c.copyValue(old))
+		if keep {
+			value = c.storeValue(value)
+		}
+		return value, keep
+	})
+}
+
+// Transfer moves key from src to dst as one operation, so no other caller
+// sees it in both caches or in neither. Both caches are locked in address
+// order, so concurrent transfers in opposite directions cannot deadlock.
+// The entry keeps its expiry deadline. Since the value stays in use, src's
+// eviction and OnRemove callbacks are not called for it. Returns whether
+// the key was in src; if not, nothing is changed.
+func Transfer(src, dst *Cache, key interface{}) bool {
+	if src == dst {
+		return src.Contains(key)
+	}
+	first, second := src, dst
+	if uintptr(unsafe.Pointer(dst)) < uintptr(unsafe.Pointer(src)) {
+		first, second = dst, src
+	}
+	first.lock.Lock()
+	second.lock.Lock()
+	defer first.unlock()
+	defer second.unlock()
+
+	value, expiresAt, ok := src.lru.Take(key)
+	if !ok {
+		return false
+	}
+	if src.members != nil {
+		src.members.Delete(key)
+	}
+	dst.lru.AddExpireAt(key, dst.storeValue(value), expiresAt)
+	return true
+}
+
 // Remove removes the provided key from the cache.
 func (c *Cache) Remove(key interface{}) {
 	c.lock.Lock()
+	if c.trace != nil {
+		c.trace("remove", key)
+	}
 	c.lru.Remove(key)
-	c.lock.Unlock()
+	c.unlock()
+}
+
+// RemoveAddedBefore removes every entry inserted before t, regardless of
+// its expiry, returning the number removed. It is meant for bulk
+// invalidation after a known change point, such as a config reload.
+func (c *Cache) RemoveAddedBefore(t time.Time) int {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.RemoveAddedBefore(t)
+}
+
+// EvictOlderThan removes every entry not added, updated or read with Get
+// within the last age, returning the number removed, independently of
+// capacity and expiry. Peek and Contains do not count as reads. It removes
+// nothing unless the cache was constructed with NewWithAccessTracking.
+func (c *Cache) EvictOlderThan(age time.Duration) int {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.RemoveAccessedBefore(time.Now().Add(-age))
+}
+
+// Demote moves the provided key to the back of the eviction list so it is
+// the next entry to be evicted, without changing its value. Returns whether
+// the key was contained.
+func (c *Cache) Demote(key interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.lru.Demote(key)
+}
+
+// RemoveAllReport removes the provided keys from the cache under a single
+// lock, returning the subset of keys that were actually cached.
+func (c *Cache) RemoveAllReport(keys []interface{}) (removed []interface{}) {
+	c.lock.Lock()
+	defer c.unlock()
+
+	for _, k := range keys {
+		if c.lru.Contains(k) {
+			removed = append(removed, k)
+		}
+		c.lru.Remove(k)
+	}
+	return removed
 }
 
 // RemoveOldest removes the oldest item from the cache.
 func (c *Cache) RemoveOldest() {
 	c.lock.Lock()
 	c.lru.RemoveOldest()
-	c.lock.Unlock()
+	c.unlock()
+}
+
+// PeekN returns up to n of the most recently used entries, newest first,
+// without updating their recent-ness. It is cheaper than Keys when only
+// the hottest few entries are needed.
+func (c *Cache) PeekN(n int) []KV {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	kvs := c.lru.PeekN(n)
+	for i := range kvs {
+		kvs[i].Value = c.copyValue(kvs[i].Value)
+	}
+	return kvs
+}
+
+// Entries returns all entries in the cache, most recently used first,
+// without updating their recent-ness. Keys and values are taken under one
+// lock, so they are always consistent with each other.
+func (c *Cache) Entries() []KV {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	kvs := c.lru.PeekN(c.lru.Len())
+	for i := range kvs {
+		kvs[i].Value = c.copyValue(kvs[i].Value)
+	}
+	return kvs
 }
 
 // Keys returns a slice of the keys in the cache, from oldest to newest.
+// Expired keys and keys invalidated by Bump are not included, even while
+// they still count towards Len.
 func (c *Cache) Keys() []interface{} {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	return c.lru.Keys()
 }
 
+// KeysCopy returns the keys in the cache from oldest to newest, each
+// passed through copy, so that callers get defensive copies of keys such
+// as pointers to structs rather than the keys the cache holds.
+func (c *Cache) KeysCopy(copy func(key interface{}) interface{}) []interface{} {
+	keys := c.Keys()
+	for i, k := range keys {
+		keys[i] = copy(k)
+	}
+	return keys
+}
+
+// KeysNewestFirst returns a slice of the keys in the cache, from newest to
+// oldest.
+func (c *Cache) KeysNewestFirst() []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.KeysNewestFirst()
+}
+
+// Iterator returns an iterator over a snapshot of the keys in the cache,
+// from oldest to newest. The cache is only locked while taking the snapshot
+// and while fetching each value, so writers are not blocked during a slow
+// iteration.
+func (c *Cache) Iterator() *Iterator {
+	return &Iterator{cache: c, keys: c.Keys()}
+}
+
+// Iterator walks the entries of a Cache without holding its lock. Values
+// are fetched as the iteration reaches them, so entries removed since the
+// snapshot are skipped and updated ones yield their current value. An
+// Iterator is not safe for concurrent use.
+type Iterator struct {
+	cache *Cache
+	keys  []interface{}
+}
+
+// Next returns the next entry still in the cache, without updating its
+// recent-ness, or false once the iteration is complete.
+func (it *Iterator) Next() (KV, bool) {
+	for len(it.keys) > 0 {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+		if value, ok := it.cache.Peek(key); ok {
+			return KV{Key: key, Value: value}, true
+		}
+	}
+	return KV{}, false
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	c.lock.RLock()
@@ -113,11 +1027,165 @@ func (c *Cache) Len() int {
 	return c.lru.Len()
 }
 
+// Full reports whether the cache holds as many items as its current size
+// allows.
+func (c *Cache) Full() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.full()
+}
+
+// full reports whether the cache is at capacity; a soft capped cache never
+// is.
+func (c *Cache) full() bool {
+	return !c.softCapped && c.lru.Len() >= c.lru.Size()
+}
+
+// ApproxBytes estimates the memory held by the cache, summing sizeOf over
+// every entry plus ApproxEntryOverhead per entry.
+func (c *Cache) ApproxBytes(sizeOf func(key, value interface{}) int64) int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var total int64
+	for _, k := range c.lru.Keys() {
+		v, _ := c.lru.Peek(k)
+		total += sizeOf(k, c.copyValue(v)) + ApproxEntryOverhead
+	}
+	return total
+}
+
+// KeyBytes sums sizeOf over every key in the cache, so key memory can be
+// accounted for separately from values. No overhead is added.
+func (c *Cache) KeyBytes(sizeOf func(key interface{}) int64) int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var total int64
+	for _, k := range c.lru.Keys() {
+		total += sizeOf(k)
+	}
+	return total
+}
+
+// CountFunc returns the number of entries for which pred returns true,
+// without updating their recent-ness or removing anything.
+func (c *Cache) CountFunc(pred func(key, value interface{}) bool) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	n := 0
+	for _, k := range c.lru.Keys() {
+		if v, ok := c.lru.Peek(k); ok && pred(k, c.copyValue(v)) {
+			n++
+		}
+	}
+	return n
+}
+
+// GroupBy returns the number of entries in each bucket named by bucket, in
+// a single walk under one lock and without updating recent-ness. It suits
+// occupancy reports such as entries per tenant.
+func (c *Cache) GroupBy(bucket func(key, value interface{}) string) map[string]int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	counts := make(map[string]int)
+	for _, k := range c.lru.Keys() {
+		if v, ok := c.lru.Peek(k); ok {
+			counts[bucket(k, c.copyValue(v))]++
+		}
+	}
+	return counts
+}
+
+// Consistent reports whether the cache's key index and eviction list agree
+// on the number of entries. It takes the read lock only briefly and runs in
+// constant time, so it is safe to call often, for example from a liveness
+// probe; false means the cache has been corrupted and should not be
+// trusted.
+func (c *Cache) Consistent() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.Consistent()
+}
+
+// PauseEviction stops the cache from evicting, so it can be bulk loaded
+// beyond its size. Entries keep their usual recency ordering meanwhile.
+func (c *Cache) PauseEviction() {
+	c.lock.Lock()
+	c.lru.PauseEviction()
+	c.unlock()
+}
+
+// ResumeEviction re-enables eviction after PauseEviction, evicting the
+// oldest entries until the cache is back within its size.
+func (c *Cache) ResumeEviction() {
+	c.Trim()
+}
+
+// Trim evicts the oldest entries until the cache is within its size,
+// returning the number evicted. For a soft capped cache this is the only
+// way entries are evicted.
+func (c *Cache) Trim() int {
+	c.lock.Lock()
+	defer c.unlock()
+
+	evicted := c.lru.ResumeEviction()
+	if c.softCapped {
+		c.lru.PauseEviction()
+	}
+	return evicted
+}
+
+// Bump invalidates every entry currently in the cache in O(1) by starting
+// a new generation. Older entries are no longer visible to Get, Peek,
+// Contains or Keys and are reclaimed lazily, so they still count towards Len until
+// looked up or evicted.
+func (c *Cache) Bump() {
+	c.lock.Lock()
+	c.lru.Bump()
+	c.unlock()
+}
+
+// Generation returns the current generation, which Bump increments.
+func (c *Cache) Generation() uint64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.Generation()
+}
+
+// DirtyKeys returns the keys added or updated since the last ClearDirty,
+// in no particular order. Keys that have since been removed or evicted are
+// not included. It is always empty unless the cache was constructed with
+// NewWithDirtyTracking.
+func (c *Cache) DirtyKeys() []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.DirtyKeys()
+}
+
+// ClearDirty marks every key in the cache as clean.
+func (c *Cache) ClearDirty() {
+	c.lock.Lock()
+	c.lru.ClearDirty()
+	c.unlock()
+}
+
+// Shrink releases the memory the cache's key index keeps after bulk
+// removals, without changing its entries or their order. Call it after a
+// large invalidation to return the space to the runtime.
+func (c *Cache) Shrink() {
+	c.lock.Lock()
+	c.lru.Shrink()
+	c.unlock()
+}
+
 // Resize changes the cache size.
 func (c *Cache) Resize(size int) (evicted int) {
 	c.lock.Lock()
 	evicted = c.lru.Resize(size)
-	c.lock.Unlock()
+	c.unlock()
 	return evicted
 }
 
@@ -125,7 +1193,23 @@ func (c *Cache) Resize(size int) (evicted int) {
 func (c *Cache) GetOldest() (key interface{}, value interface{}, ok bool) {
 	c.lock.Lock()
 	key, value, ok = c.lru.GetOldest()
-	c.lock.Unlock()
+	if ok {
+		value = c.copyValue(value)
+	}
+	c.unlock()
+	return
+}
+
+// GetOldestUnpinned returns the oldest entry that is not pinned against
+// eviction, i.e. the entry the next eviction would remove. Unlike
+// GetOldest it skips entries that eviction currently refuses.
+func (c *Cache) GetOldestUnpinned() (key interface{}, value interface{}, ok bool) {
+	c.lock.RLock()
+	key, value, ok = c.lru.GetOldestEvictable()
+	if ok {
+		value = c.copyValue(value)
+	}
+	c.lock.RUnlock()
 	return
 }
 
@@ -134,13 +1218,31 @@ func (c *Cache) GetOldest() (key interface{}, value interface{}, ok bool) {
 // Returns whether found and whether an eviction occurred.
 func (c *Cache) PeekOrAdd(key, value interface{}) (previous interface{}, ok, evicted bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	defer c.unlock()
 
 	previous, ok = c.lru.Peek(key)
 	if ok {
-		return previous, true, false
+		return c.copyValue(previous), true, false
 	}
 
-	evicted = c.lru.Add(key, value)
+	evicted = c.lru.Add(key, c.storeValue(value))
 	return nil, false, evicted
 }
+
+// storeValue prepares a value for storing, passing it through the
+// transform and then the copy function where the cache has them.
+func (c *Cache) storeValue(value interface{}) interface{} {
+	if c.transform != nil && value != Loading {
+		value = c.transform(value)
+	}
+	return c.copyValue(value)
+}
+
+// copyValue returns a copy of value if the cache was constructed with a
+// copy function, and value itself otherwise.
+func (c *Cache) copyValue(value interface{}) interface{} {
+	if c.copy == nil || value == Loading {
+		return value
+	}
+	return c.copy(value)
+}
@@ -1,8 +1,14 @@
 package lru
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 )
 
 func BenchmarkLRU_Rand(b *testing.B) {
@@ -66,6 +72,29 @@ func BenchmarkLRU_Freq(b *testing.B) {
 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
 }
 
+func BenchmarkLRU_GetHit(b *testing.B) {
+	l, err := New(8192)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	// Box the keys up front so only Get itself is measured
+	keys := make([]interface{}, 8192)
+	for i := range keys {
+		keys[i] = int64(i) + 1000
+		l.Add(keys[i], i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok := l.Get(keys[i%len(keys)]); !ok {
+			b.Fatalf("missing: %v", keys[i%len(keys)])
+		}
+	}
+}
+
 func TestLRU(t *testing.T) {
 	evictCounter := 0
 	onEvicted := func(k interface{}, v interface{}) {
@@ -291,3 +320,1673 @@ func TestLRUPeekOrAdd(t *testing.T) {
 		t.Errorf("now 1 should be contained")
 	}
 }
+
+// test that a batch-evicting cache never exceeds its size
+func TestLRUEvictBatch(t *testing.T) {
+	l, err := NewWithEvictBatch(8, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.Add(i, i)
+		if l.Len() > 11 {
+			t.Fatalf("len should not pass size+batch: %v", l.Len())
+		}
+	}
+	if l.Len() < 8 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if !l.Contains(99) {
+		t.Fatalf("newest entry should be contained")
+	}
+}
+
+// test that Demote marks an entry as the next eviction candidate
+func TestLRUDemote(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.Demote(2) {
+		t.Errorf("2 should be contained")
+	}
+	if l.Demote(3) {
+		t.Errorf("3 should not be contained")
+	}
+
+	l.Add(3, 3)
+	if l.Contains(2) {
+		t.Errorf("2 should have been evicted")
+	}
+	if !l.Contains(1) {
+		t.Errorf("1 should be contained")
+	}
+}
+
+// test that AddCold entries are evicted before hot ones
+func TestLRUAddCold(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.AddCold(2, 2)
+	l.Add(3, 3)
+	if l.Contains(2) {
+		t.Errorf("2 should have been evicted first")
+	}
+	if !l.Contains(1) || !l.Contains(3) {
+		t.Errorf("1 and 3 should be contained")
+	}
+}
+
+// test that canEvict vetoes evictions and lets the cache overflow
+func TestLRUCanEvict(t *testing.T) {
+	canEvict := func(k interface{}, v interface{}) bool {
+		return k != 1
+	}
+	l, err := NewWithCanEvict(2, nil, canEvict)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	if !l.Contains(1) || l.Contains(2) {
+		t.Errorf("2 should have been evicted instead of 1")
+	}
+
+	l.Add(4, 4)
+	l.Add(5, 5)
+	if !l.Contains(1) || l.Contains(4) || !l.Contains(5) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+}
+
+// test that a copying cache never hands out its stored value
+func TestLRUCopyFunc(t *testing.T) {
+	copyFunc := func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	}
+	l, err := NewWithCopyFunc(2, copyFunc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	in := []int{1, 2}
+	l.Add(1, in)
+	in[0] = 100
+
+	v, ok := l.Get(1)
+	if !ok || v.([]int)[0] != 1 {
+		t.Fatalf("Add should have stored a copy: %v", v)
+	}
+	v.([]int)[0] = 200
+
+	v, _ = l.Peek(1)
+	if v.([]int)[0] != 1 {
+		t.Fatalf("Get should have returned a copy: %v", v)
+	}
+	v.([]int)[0] = 300
+
+	if v, _ := l.Get(1); v.([]int)[0] != 1 {
+		t.Fatalf("Peek should have returned a copy: %v", v)
+	}
+}
+
+// test that PeekN returns the most recent entries first
+func TestLRUPeekN(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+	}
+	l.Get(0)
+
+	kvs := l.PeekN(3)
+	if len(kvs) != 3 {
+		t.Fatalf("bad len: %v", len(kvs))
+	}
+	for i, want := range []int{0, 7, 6} {
+		if kvs[i].Key != want || kvs[i].Value != want {
+			t.Errorf("bad entry %d: %v", i, kvs[i])
+		}
+	}
+}
+
+// test that CompareAndSwap only swaps a matching value
+func TestLRUCompareAndSwap(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.CompareAndSwap(1, nil, 1) {
+		t.Errorf("missing key should not be swapped")
+	}
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if l.CompareAndSwap(1, 2, 10) {
+		t.Errorf("mismatched value should not be swapped")
+	}
+	if !l.CompareAndSwap(1, 1, 10) {
+		t.Errorf("matching value should be swapped")
+	}
+	if v, _ := l.Peek(1); v != 10 {
+		t.Errorf("1 should be set to 10: %v", v)
+	}
+
+	l.Add(3, 3)
+	if !l.Contains(1) || l.Contains(2) {
+		t.Errorf("CompareAndSwap should have updated recent-ness of 1")
+	}
+
+	b := []byte("v")
+	l.Add(4, b)
+	if l.CompareAndSwap(4, b, []byte("w")) {
+		t.Errorf("uncomparable values should never be swapped")
+	}
+}
+
+// test that KeysNewestFirst orders keys from newest to oldest
+func TestLRUKeysNewestFirst(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Get(1)
+
+	for i, k := range l.KeysNewestFirst() {
+		if k != []int{1, 3, 2}[i] {
+			t.Errorf("out of order key: %v", k)
+		}
+	}
+}
+
+// test that AddExpireAt entries are missing once their deadline passes
+func TestLRUAddExpireAt(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddExpireAt(1, 1, time.Now().Add(time.Hour))
+	l.AddExpireAt(2, 2, time.Now().Add(-time.Hour))
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if _, ok := l.Peek(2); ok {
+		t.Errorf("2 should be expired")
+	}
+	if _, ok := l.Get(2); ok {
+		t.Errorf("2 should be expired")
+	}
+
+	// An expired entry can be replaced as if it were missing
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Hour))
+	if ok, _ := l.ContainsOrAdd(3, 30); ok {
+		t.Errorf("3 should be expired")
+	}
+	if v, ok := l.Get(3); !ok || v != 30 {
+		t.Errorf("3 should be set to 30: %v, %v", v, ok)
+	}
+}
+
+// test that FlushTo writes entries oldest-first and then purges
+func TestLRUFlushTo(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, "a")
+	l.Add(2, "b")
+	l.Add(3, "c")
+	l.Get(1)
+
+	var buf bytes.Buffer
+	encode := func(w io.Writer, kv KV) error {
+		_, err := fmt.Fprintf(w, "%v=%v;", kv.Key, kv.Value)
+		return err
+	}
+	n, err := l.FlushTo(&buf, encode)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 3 || buf.String() != "2=b;3=c;1=a;" {
+		t.Errorf("bad flush: %d %q", n, buf.String())
+	}
+	if l.Len() != 0 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	// A failed flush leaves the cache untouched
+	l.Add(1, "a")
+	l.Add(2, "b")
+	failing := func(w io.Writer, kv KV) error {
+		if kv.Key == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	}
+	if n, err := l.FlushTo(&buf, failing); err == nil || n != 1 {
+		t.Errorf("bad flush: %d %v", n, err)
+	}
+	if l.Len() != 2 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// test that Full follows the length and Resize
+func TestLRUFull(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if l.Full() {
+		t.Errorf("should not be full")
+	}
+	l.Add(2, 2)
+	if !l.Full() {
+		t.Errorf("should be full")
+	}
+	l.Resize(3)
+	if l.Full() {
+		t.Errorf("should not be full after upsizing")
+	}
+	l.Resize(1)
+	if !l.Full() {
+		t.Errorf("should be full after downsizing")
+	}
+}
+
+// test that Merge folds other's entries in in recency order
+func TestLRUMerge(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	other, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	other.Add(2, 20)
+	other.Add(3, 30)
+	other.Add(4, 40)
+
+	l.Merge(other)
+	if l.Len() != 3 || l.Contains(1) {
+		t.Errorf("1 should have been evicted: %v", l.Keys())
+	}
+	if v, _ := l.Peek(2); v != 20 {
+		t.Errorf("other's value should win: %v", v)
+	}
+	for i, k := range l.Keys() {
+		if k != []int{2, 3, 4}[i] {
+			t.Errorf("out of order key: %v", k)
+		}
+	}
+	if other.Len() != 3 {
+		t.Errorf("other should be untouched: %v", other.Len())
+	}
+}
+
+// test that GetOrLoad caches loaded values and coalesces loads
+func TestLRUGetOrLoad(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := l.GetOrLoad(1, func() (interface{}, error) {
+		return nil, errors.New("boom")
+	}); err == nil {
+		t.Errorf("load error should be returned")
+	}
+	if l.Contains(1) {
+		t.Errorf("errors should not be cached")
+	}
+
+	var mu sync.Mutex
+	loads := 0
+	release := make(chan struct{})
+	load := func() (interface{}, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		<-release
+		return 1, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := l.GetOrLoad(1, load); err != nil || v != 1 {
+				t.Errorf("bad load: %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("loads should have been coalesced: %v", loads)
+	}
+	if v, ok := l.Peek(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if v, err := l.GetOrLoad(1, load); err != nil || v != 1 || loads != 1 {
+		t.Errorf("cached value should be returned without loading: %v, %v", v, err)
+	}
+}
+
+// test that ApproxBytes sums entry sizes and overhead
+func TestLRUApproxBytes(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sizeOf := func(k, v interface{}) int64 {
+		return int64(len(v.(string)))
+	}
+	if n := l.ApproxBytes(sizeOf); n != 0 {
+		t.Errorf("bad size: %v", n)
+	}
+	l.Add(1, "abc")
+	l.Add(2, "de")
+	if n := l.ApproxBytes(sizeOf); n != 5+2*ApproxEntryOverhead {
+		t.Errorf("bad size: %v", n)
+	}
+}
+
+// test that ApproxBytes hands copies to sizeOf on a copying cache
+func TestLRUApproxBytesCopy(t *testing.T) {
+	l, err := NewWithCopyFunc(2, func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, []int{1})
+	l.ApproxBytes(func(k, v interface{}) int64 {
+		v.([]int)[0] = 100
+		return 0
+	})
+	if v, _ := l.Peek(1); v.([]int)[0] != 1 {
+		t.Errorf("ApproxBytes should have passed a copy: %v", v)
+	}
+}
+
+// test that KeyBytes sums key sizes only
+func TestLRUKeyBytes(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sizeOf := func(k interface{}) int64 {
+		return int64(len(k.(string)))
+	}
+	if n := l.KeyBytes(sizeOf); n != 0 {
+		t.Errorf("bad size: %v", n)
+	}
+	l.Add("abc", "value")
+	l.Add("de", "value")
+	if n := l.KeyBytes(sizeOf); n != 5 {
+		t.Errorf("bad size: %v", n)
+	}
+	l.Add("f", "value")
+	if n := l.KeyBytes(sizeOf); n != 3 {
+		t.Errorf("evicted key should not count: %v", n)
+	}
+}
+
+// test that Iterator tolerates modification during iteration
+func TestLRUIterator(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+
+	it := l.Iterator()
+	kv, ok := it.Next()
+	if !ok || kv.Key != 1 || kv.Value != 1 {
+		t.Fatalf("bad entry: %v, %v", kv, ok)
+	}
+	l.Remove(2)
+	l.Add(3, 30)
+	l.Add(4, 4)
+
+	kv, ok = it.Next()
+	if !ok || kv.Key != 3 || kv.Value != 30 {
+		t.Fatalf("bad entry: %v, %v", kv, ok)
+	}
+	if kv, ok := it.Next(); ok {
+		t.Fatalf("iteration should be complete: %v", kv)
+	}
+}
+
+// test that CountFunc counts matching entries without changing them
+func TestLRUCountFunc(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+	}
+	even := func(k, v interface{}) bool {
+		return v.(int)%2 == 0
+	}
+	if n := l.CountFunc(even); n != 4 {
+		t.Errorf("bad count: %v", n)
+	}
+	if l.Len() != 8 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	if k, _, _ := l.GetOldest(); k != 0 {
+		t.Errorf("CountFunc should not have updated recent-ness: %v", k)
+	}
+}
+
+// test that CountFunc hands copies to pred on a copying cache
+func TestLRUCountFuncCopy(t *testing.T) {
+	l, err := NewWithCopyFunc(2, func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, []int{1})
+	l.CountFunc(func(k, v interface{}) bool {
+		v.([]int)[0] = 100
+		return true
+	})
+	if v, _ := l.Peek(1); v.([]int)[0] != 1 {
+		t.Errorf("CountFunc should have passed a copy: %v", v)
+	}
+}
+
+// test that eviction can be paused for a bulk load
+func TestLRUPauseEviction(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.PauseEviction()
+	for i := 0; i < 10; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 10 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	l.ResumeEviction()
+	if l.Len() != 4 || l.Contains(5) || !l.Contains(6) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+}
+
+// test that GetOldestUnpinned returns the actual eviction victim
+func TestLRUGetOldestUnpinned(t *testing.T) {
+	canEvict := func(k interface{}, v interface{}) bool {
+		return k != 1
+	}
+	l, err := NewWithCanEvict(2, nil, canEvict)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	k, _, ok := l.GetOldestUnpinned()
+	if !ok || k != 2 {
+		t.Fatalf("2 should be the victim: %v, %v", k, ok)
+	}
+	l.Add(3, 3)
+	if l.Contains(k) {
+		t.Errorf("%v should have been evicted", k)
+	}
+}
+
+// test that AddManyReturningEvicted reports evictions in order
+func TestLRUAddManyReturningEvicted(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewWithEvict(2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	evicted := l.AddManyReturningEvicted([]KV{{Key: 2, Value: 2}, {Key: 3, Value: 3}, {Key: 4, Value: 4}})
+	if len(evicted) != 2 || evicted[0] != (KV{Key: 1, Value: 1}) || evicted[1] != (KV{Key: 2, Value: 2}) {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+	if evictCounter != 2 {
+		t.Errorf("onEvicted should still be called: %v", evictCounter)
+	}
+
+	// Evictions outside the batch are not reported
+	l.Add(5, 5)
+	if evicted := l.AddManyReturningEvicted([]KV{{Key: 5, Value: 50}}); len(evicted) != 0 {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+}
+
+// test that Rank reflects recency
+func TestLRURank(t *testing.T) {
+	l, err := New(3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	if rank, ok := l.Rank(1); !ok || rank != 2 {
+		t.Errorf("1 should be next to evict: %v, %v", rank, ok)
+	}
+	l.Get(1)
+	if rank, ok := l.Rank(1); !ok || rank != 0 {
+		t.Errorf("1 should be the most recent: %v, %v", rank, ok)
+	}
+	if _, ok := l.Rank(4); ok {
+		t.Errorf("4 should not be contained")
+	}
+}
+
+// test that OnCapacityChange fires when crossing the size boundary
+func TestLRUOnCapacityChange(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var changes []bool
+	l.OnCapacityChange(func(full bool) {
+		changes = append(changes, full)
+		l.Len() // the lock must already be released
+	})
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Remove(3)
+	l.Add(4, 4)
+	l.Add(5, 5)
+	l.Purge()
+
+	want := []bool{true, false, true, false}
+	if len(changes) != len(want) {
+		t.Fatalf("bad changes: %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("bad changes: %v", changes)
+		}
+	}
+}
+
+// test that RemoveAllReport returns only the keys that were cached
+func TestLRURemoveAllReport(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	removed := l.RemoveAllReport([]interface{}{3, 4, 1})
+	if len(removed) != 2 || removed[0] != 3 || removed[1] != 1 {
+		t.Errorf("bad removed keys: %v", removed)
+	}
+	if l.Len() != 1 || !l.Contains(2) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+	if removed := l.RemoveAllReport(nil); len(removed) != 0 {
+		t.Errorf("bad removed keys: %v", removed)
+	}
+}
+
+// test that a cache hit on an int key does not allocate
+func TestLRUGetNoAllocs(t *testing.T) {
+	l, err := New(128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	keys := make([]interface{}, 128)
+	for i := range keys {
+		keys[i] = int64(i) + 1000
+		l.Add(keys[i], i)
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		l.Get(keys[i%len(keys)])
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("Get should not allocate: %v allocs/op", allocs)
+	}
+}
+
+// test that Swap returns the previous value
+func TestLRUSwap(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if old, existed := l.Swap(1, 1); existed || old != nil {
+		t.Errorf("1 should not have existed: %v, %v", old, existed)
+	}
+	l.Add(2, 2)
+	if old, existed := l.Swap(1, 10); !existed || old != 1 {
+		t.Errorf("1 should have been 1: %v, %v", old, existed)
+	}
+	if v, _ := l.Peek(1); v != 10 {
+		t.Errorf("1 should be set to 10: %v", v)
+	}
+
+	l.Add(3, 3)
+	if !l.Contains(1) || l.Contains(2) {
+		t.Errorf("Swap should have updated recent-ness of 1")
+	}
+}
+
+// test that OnRemove reports removals except those applied from peers
+func TestLRUOnRemove(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewWithEvict(2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var removed []interface{}
+	l.OnRemove(func(key interface{}) {
+		removed = append(removed, key)
+	})
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Remove(2)
+	l.ApplyRemove(3)
+	if len(removed) != 2 || removed[0] != 1 || removed[1] != 2 {
+		t.Errorf("bad removals: %v", removed)
+	}
+	if l.Len() != 0 {
+		t.Errorf("ApplyRemove should have removed 3")
+	}
+	if evictCounter != 3 {
+		t.Errorf("onEvicted should still be called: %v", evictCounter)
+	}
+}
+
+// test that a soft capped cache only shrinks on Trim
+func TestLRUSoftCapped(t *testing.T) {
+	l, err := NewSoftCapped(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if l.Add(i, i) {
+			t.Errorf("should not have an eviction")
+		}
+	}
+	if l.Len() != 5 || l.Full() {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	if evicted := l.Trim(); evicted != 3 {
+		t.Errorf("3 elements should have been evicted: %v", evicted)
+	}
+	if !l.Contains(3) || !l.Contains(4) {
+		t.Errorf("the newest entries should be kept: %v", l.Keys())
+	}
+
+	l.ResumeEviction()
+	l.Add(5, 5)
+	if l.Len() != 3 {
+		t.Errorf("should stay unbounded: %v", l.Len())
+	}
+}
+
+// test that a scan does not promote entries below the threshold
+func TestLRUPromoteThreshold(t *testing.T) {
+	l, err := NewWithPromoteThreshold(3, 2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Get(3)
+	l.Get(3)
+	l.Get(1)
+	l.Add(4, 4)
+	if l.Contains(1) {
+		t.Errorf("a single Get should not have saved 1")
+	}
+	l.Add(5, 5)
+	if !l.Contains(3) || l.Contains(2) {
+		t.Errorf("3 should have been promoted: %v", l.Keys())
+	}
+}
+
+// test that Entries returns everything most-recent-first
+func TestLRUEntries(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if kvs := l.Entries(); len(kvs) != 0 {
+		t.Errorf("should be empty: %v", kvs)
+	}
+	l.Add(1, "a")
+	l.Add(2, "b")
+	l.AddExpireAt(3, "c", time.Now().Add(-time.Second))
+	l.Add(4, "d")
+	l.Get(1)
+
+	want := []KV{{Key: 1, Value: "a"}, {Key: 4, Value: "d"}, {Key: 2, Value: "b"}}
+	kvs := l.Entries()
+	if len(kvs) != len(want) {
+		t.Fatalf("bad entries: %v", kvs)
+	}
+	for i := range want {
+		if kvs[i] != want[i] {
+			t.Errorf("bad entry %d: %v", i, kvs[i])
+		}
+	}
+}
+
+// test that placeholders report Loading until fulfilled
+func TestLRUPlaceholder(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.AddPlaceholder(1) {
+		t.Errorf("1 should not have existed")
+	}
+	if !l.AddPlaceholder(1) {
+		t.Errorf("1 should already exist")
+	}
+	if v, ok := l.Get(1); !ok || v != Loading {
+		t.Errorf("1 should be loading: %v, %v", v, ok)
+	}
+	if !l.FulfillPlaceholder(1, 10) {
+		t.Errorf("placeholder should have been fulfilled")
+	}
+	if v, ok := l.Get(1); !ok || v != 10 {
+		t.Errorf("1 should be set to 10: %v, %v", v, ok)
+	}
+	if l.FulfillPlaceholder(1, 20) {
+		t.Errorf("a fulfilled placeholder should not be fulfilled again")
+	}
+
+	l.Add(2, 2)
+	if !l.AddPlaceholder(2) || l.FulfillPlaceholder(2, 20) || l.FulfillPlaceholder(3, 3) {
+		t.Errorf("only placeholders should be fulfilled")
+	}
+	if v, _ := l.Peek(2); v != 2 {
+		t.Errorf("2 should be set to 2: %v", v)
+	}
+}
+
+// test that fresh entries survive write pressure
+func TestLRUMinResidency(t *testing.T) {
+	l, err := NewWithMinResidency(2, time.Hour)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 5 {
+		t.Errorf("no entry should have been old enough to evict: %v", l.Len())
+	}
+	for i := 0; i < 5; i++ {
+		if !l.Contains(i) {
+			t.Errorf("%d should be contained", i)
+		}
+	}
+}
+
+// test that nil keys can be refused
+func TestLRUNilKeys(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := l.TryAdd(nil, 1); err != ErrNilKey {
+		t.Errorf("should have rejected the nil key: %v", err)
+	}
+	if _, err := l.TryAdd(1, 1); err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	l.RejectNilKeys()
+	l.Add(nil, 1)
+	l.ContainsOrAdd(nil, 1)
+	if l.Contains(nil) || l.Len() != 1 {
+		t.Errorf("nil keys should have been ignored")
+	}
+}
+
+// test that Bump invalidates everything added before it
+func TestLRUBump(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Bump()
+	l.Add(2, 2)
+	if l.Generation() != 1 {
+		t.Errorf("bad generation: %v", l.Generation())
+	}
+	if l.Contains(1) {
+		t.Errorf("1 should be from an old generation")
+	}
+	if keys := l.Keys(); len(keys) != 1 || keys[0] != 2 {
+		t.Errorf("old generation should not be listed: %v", keys)
+	}
+	if v, ok := l.Get(2); !ok || v != 2 {
+		t.Errorf("2 should be set to 2: %v, %v", v, ok)
+	}
+	if ok, _ := l.ContainsOrAdd(1, 10); ok {
+		t.Errorf("1 should be treated as missing")
+	}
+	if v, _ := l.Get(1); v != 10 {
+		t.Errorf("1 should be set to 10: %v", v)
+	}
+}
+
+// test that NewFromMap populates the cache within its size
+func TestLRUNewFromMap(t *testing.T) {
+	m := map[interface{}]interface{}{1: "a", 2: "b", 3: "c"}
+	l, err := NewFromMap(4, m)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if l.Len() != 3 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	for k, v := range m {
+		if got, ok := l.Peek(k); !ok || got != v {
+			t.Errorf("%v should be set to %v: %v, %v", k, v, got, ok)
+		}
+	}
+
+	l, err = NewFromMap(2, m)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if l.Len() != 2 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	if _, err := NewFromMap(0, m); err == nil {
+		t.Errorf("should reject an invalid size")
+	}
+}
+
+// test that onAdd fires for inserts and updates
+func TestLRUOnAdd(t *testing.T) {
+	var news, updates int
+	onAdd := func(k, v interface{}, isUpdate bool) {
+		if isUpdate {
+			updates++
+		} else {
+			news++
+		}
+	}
+	l, err := NewWithOnAdd(2, onAdd)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(1, 10)
+	l.Swap(2, 2)
+	l.ContainsOrAdd(2, 2)
+	l.Get(1)
+	if news != 2 || updates != 1 {
+		t.Errorf("bad adds: %d new %d updates", news, updates)
+	}
+}
+
+// test that DirtyKeys reports writes since the last ClearDirty
+func TestLRUDirtyKeys(t *testing.T) {
+	l, err := NewWithDirtyTracking(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Get(1)
+	if keys := l.DirtyKeys(); len(keys) != 2 {
+		t.Errorf("bad dirty keys: %v", keys)
+	}
+	l.ClearDirty()
+	l.Swap(1, 10)
+	l.Peek(2)
+	if keys := l.DirtyKeys(); len(keys) != 1 || keys[0] != 1 {
+		t.Errorf("bad dirty keys: %v", keys)
+	}
+}
+
+// test that RemoveAddedBefore invalidates everything loaded before a point
+func TestLRURemoveAddedBefore(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	l.Add(4, 4)
+
+	if n := l.RemoveAddedBefore(cutoff); n != 4 {
+		t.Errorf("bad removed count: %d", n)
+	}
+	if l.Len() != 1 || !l.Contains(4) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+	if n := l.RemoveAddedBefore(cutoff); n != 0 {
+		t.Errorf("nothing left to remove: %d", n)
+	}
+}
+
+// test that EvictOlderThan drops entries not used recently
+func TestLRUEvictOlderThan(t *testing.T) {
+	l, err := NewWithAccessTracking(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	l.Get(0)
+	l.Add(1, 10)
+
+	if n := l.EvictOlderThan(10 * time.Millisecond); n != 2 {
+		t.Errorf("bad removed count: %d", n)
+	}
+	if l.Len() != 2 || !l.Contains(0) || !l.Contains(1) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+
+	plain, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	plain.Add(1, 1)
+	if n := plain.EvictOlderThan(0); n != 0 {
+		t.Errorf("untracked cache should not remove: %d", n)
+	}
+}
+
+// test that values pass through the transform before being stored
+func TestLRUTransform(t *testing.T) {
+	calls := 0
+	l, err := NewWithTransform(4, func(v interface{}) interface{} {
+		calls++
+		return v.(int) * 10
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if v, ok := l.Get(1); !ok || v != 10 {
+		t.Errorf("bad value: %v", v)
+	}
+	if v, _ := l.GetOrLoad(2, func() (interface{}, error) { return 2, nil }); v != 20 {
+		t.Errorf("loaded value should be transformed: %v", v)
+	}
+	if v, ok := l.Peek(2); !ok || v != 20 {
+		t.Errorf("bad value: %v", v)
+	}
+	if l.AddPlaceholder(3) {
+		t.Fatalf("3 should not exist")
+	}
+	if calls != 2 {
+		t.Errorf("placeholder should not be transformed: %d calls", calls)
+	}
+	l.FulfillPlaceholder(3, 3)
+	if v, _ := l.Get(3); v != 30 {
+		t.Errorf("fulfilled value should be transformed: %v", v)
+	}
+}
+
+// test that an entry held by GetForUse survives eviction pressure
+func TestLRUGetForUse(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	v, release, ok := l.GetForUse(1)
+	if !ok || v != 1 {
+		t.Fatalf("bad value: %v", v)
+	}
+	for i := 2; i < 10; i++ {
+		l.Add(i, i)
+	}
+	if !l.Contains(1) {
+		t.Errorf("1 should not be evicted while in use")
+	}
+
+	release()
+	l.Demote(1)
+	l.Add(10, 10)
+	if l.Contains(1) {
+		t.Errorf("1 should be evicted after release")
+	}
+	if _, _, ok := l.GetForUse(1); ok {
+		t.Errorf("1 should be missing")
+	}
+	if s := l.Stats(); s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("bad stats: %+v", s)
+	}
+}
+
+// test that a batch keeps its slice order in the eviction list
+func TestLRUAddManyOrder(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(0, 0)
+	batch := make([]KV, 6)
+	for i := range batch {
+		batch[i] = KV{Key: i + 1, Value: i + 1}
+	}
+	l.AddManyReturningEvicted(batch)
+	for i, k := range l.Keys() {
+		if k != i {
+			t.Fatalf("bad key order: %v", l.Keys())
+		}
+	}
+
+	evicted := l.AddManyReturningEvicted([]KV{{Key: 7, Value: 7}, {Key: 8, Value: 8}, {Key: 9, Value: 9}})
+	if len(evicted) != 2 || evicted[0].Key != 0 || evicted[1].Key != 1 {
+		t.Errorf("bad evicted: %v", evicted)
+	}
+}
+
+// test that ContainsAll and ContainsAny probe a set of keys
+func TestLRUContainsAllAny(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("read", true)
+	l.Add("write", true)
+
+	if !l.ContainsAll([]interface{}{"read", "write"}) {
+		t.Errorf("all keys are present")
+	}
+	if l.ContainsAll([]interface{}{"read", "admin"}) {
+		t.Errorf("admin is missing")
+	}
+	if !l.ContainsAny([]interface{}{"admin", "write"}) {
+		t.Errorf("write is present")
+	}
+	if l.ContainsAny([]interface{}{"admin", "owner"}) {
+		t.Errorf("no keys are present")
+	}
+	if !l.ContainsAll(nil) || l.ContainsAny(nil) {
+		t.Errorf("bad result for no keys")
+	}
+}
+
+// test that high priority entries survive a flood of low priority ones
+func TestLRUAddWithPriority(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddWithPriority("auth", 1, 10)
+	for i := 0; i < 100; i++ {
+		l.Add(i, i)
+	}
+	if !l.Contains("auth") || l.Len() != 4 {
+		t.Errorf("auth should survive: %v", l.Keys())
+	}
+	for i := 0; i < 4; i++ {
+		l.AddWithPriority(i+100, i, 10)
+	}
+	if l.Contains("auth") {
+		t.Errorf("auth is the oldest of its tier: %v", l.Keys())
+	}
+}
+
+// test that GetSlice results line up with the keys asked for
+func TestLRUGetSlice(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	results := l.GetSlice([]interface{}{3, 1, 3, 2})
+	want := []Result{{}, {Value: 1, Found: true}, {}, {Value: 2, Found: true}}
+	if len(results) != len(want) {
+		t.Fatalf("bad results: %v", results)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("bad result %d: %v", i, results[i])
+		}
+	}
+	if s := l.Stats(); s.Hits != 2 || s.Misses != 2 {
+		t.Errorf("bad stats: %+v", s)
+	}
+
+	// 1 was promoted before 2, so it is evicted first
+	l.Add(3, 3)
+	if l.Contains(1) || !l.Contains(2) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+}
+
+// test that Shrink leaves a usable cache after bulk removal
+func TestLRUShrink(t *testing.T) {
+	l, err := New(128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 128; i++ {
+		l.Add(i, i)
+	}
+	for i := 0; i < 120; i++ {
+		l.Remove(i)
+	}
+	l.Shrink()
+	if l.Len() != 8 || !l.Contains(127) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+	for i := 0; i < 200; i++ {
+		l.Add(i+1000, i)
+	}
+	if l.Len() != 128 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+// test that Update does a read-modify-write under the lock
+func TestLRUUpdate(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("n", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Update("n", func(old interface{}) (interface{}, bool) { return old.(int) + 1, true })
+		}()
+	}
+	wg.Wait()
+	if v, _ := l.Get("n"); v != 50 {
+		t.Errorf("lost updates: %v", v)
+	}
+
+	if !l.Update("n", func(interface{}) (interface{}, bool) { return nil, false }) || l.Contains("n") {
+		t.Errorf("n should be removed")
+	}
+	if l.Update("n", func(old interface{}) (interface{}, bool) { return old, true }) {
+		t.Errorf("n no longer exists")
+	}
+}
+
+// test that GroupBy counts entries per bucket
+func TestLRUGroupBy(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("a/1", 1)
+	l.Add("a/2", 2)
+	l.Add("b/1", 3)
+	l.AddExpireAt("b/2", 4, time.Now().Add(-time.Second))
+	counts := l.GroupBy(func(k, v interface{}) string { return k.(string)[:1] })
+	if len(counts) != 2 || counts["a"] != 2 || counts["b"] != 1 {
+		t.Errorf("bad counts: %v", counts)
+	}
+
+	l.Purge()
+	if counts := l.GroupBy(func(k, v interface{}) string { return "" }); len(counts) != 0 {
+		t.Errorf("bad counts: %v", counts)
+	}
+}
+
+// test that GroupBy hands copies to bucket on a copying cache
+func TestLRUGroupByCopy(t *testing.T) {
+	l, err := NewWithCopyFunc(2, func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, []int{1})
+	l.GroupBy(func(k, v interface{}) string {
+		v.([]int)[0] = 100
+		return ""
+	})
+	if v, _ := l.Peek(1); v.([]int)[0] != 1 {
+		t.Errorf("GroupBy should have passed a copy: %v", v)
+	}
+}
+
+// test that KeysCopy hands out copies of pointer keys
+func TestLRUKeysCopy(t *testing.T) {
+	type point struct{ x, y int }
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	p1, p2 := &point{1, 2}, &point{3, 4}
+	l.Add(p1, 1)
+	l.Add(p2, 2)
+	keys := l.KeysCopy(func(k interface{}) interface{} {
+		p := *k.(*point)
+		return &p
+	})
+	if len(keys) != 2 || *keys[0].(*point) != *p1 || *keys[1].(*point) != *p2 {
+		t.Fatalf("bad keys: %v", keys)
+	}
+
+	keys[0].(*point).x = 100
+	if p1.x != 1 || !l.Contains(p1) {
+		t.Errorf("cached key should not be mutated")
+	}
+}
+
+// test that reads do not keep nearly expired entries alive
+func TestLRUFreshnessPromotion(t *testing.T) {
+	l, err := NewWithFreshnessPromotion(2, time.Minute)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddExpireAt("dying", 1, time.Now().Add(time.Second))
+	l.Add("forever", 2)
+	l.Get("dying")
+	l.Add("new", 3)
+	if l.Contains("dying") || !l.Contains("forever") {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+}
+
+// test that Transfer moves keys between caches without deadlocking
+func TestLRUTransfer(t *testing.T) {
+	l1, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l2, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l1.Add(1, "one")
+	if !Transfer(l1, l2, 1) {
+		t.Fatalf("1 should be transferred")
+	}
+	if l1.Contains(1) {
+		t.Errorf("1 should leave the source")
+	}
+	if v, ok := l2.Peek(1); !ok || v != "one" {
+		t.Errorf("bad value: %v", v)
+	}
+	if Transfer(l1, l2, 2) || l2.Contains(2) {
+		t.Errorf("missing key should not be transferred")
+	}
+	if !Transfer(l2, l2, 1) || !l2.Contains(1) {
+		t.Errorf("transfer to self should keep the key")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		src, dst := l1, l2
+		if i == 1 {
+			src, dst = l2, l1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				Transfer(src, dst, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if l1.Len()+l2.Len() != 1 {
+		t.Errorf("key should exist exactly once: %v %v", l1.Keys(), l2.Keys())
+	}
+}
+
+// test that Transfer neither reports the move as a removal nor drops the
+// entry's deadline
+func TestLRUTransferKeepsEntry(t *testing.T) {
+	closed := 0
+	l1, err := NewWithEvict(8, func(k, v interface{}) { closed++ })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	removed := 0
+	l1.OnRemove(func(k interface{}) { removed++ })
+	l2, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l1.Add(1, "conn")
+	l1.AddExpireAt(2, "two", time.Now().Add(20*time.Millisecond))
+	if !Transfer(l1, l2, 1) || !Transfer(l1, l2, 2) {
+		t.Fatalf("keys should be transferred")
+	}
+	if closed != 0 || removed != 0 {
+		t.Errorf("moved values should not be evicted: %d %d", closed, removed)
+	}
+	if !l2.Contains(2) {
+		t.Fatalf("2 should be in the destination")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if l2.Contains(2) {
+		t.Errorf("2 should keep its deadline")
+	}
+	if !l2.Contains(1) {
+		t.Errorf("1 should never expire")
+	}
+}
+
+// test that CompareVersionAndSwap only commits over the version read
+func TestLRUCompareVersionAndSwap(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, _, ok := l.GetVersioned("sum"); ok {
+		t.Errorf("sum should be missing")
+	}
+	if l.CompareVersionAndSwap("sum", 0, 1) {
+		t.Errorf("missing key should not be swapped")
+	}
+
+	l.Add("sum", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, version, _ := l.GetVersioned("sum")
+				if l.CompareVersionAndSwap("sum", version, v.(int)+1) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := l.Get("sum"); v != 20 {
+		t.Errorf("lost updates: %v", v)
+	}
+
+	// The same value written again still gets a new version
+	_, version, _ := l.GetVersioned("sum")
+	l.Add("sum", 20)
+	if l.CompareVersionAndSwap("sum", version, 21) {
+		t.Errorf("stale version should not be swapped")
+	}
+}
+
+// test that PurgeOrdered tears entries down in LRU order
+func TestLRUPurgeOrdered(t *testing.T) {
+	var order []interface{}
+	l, err := NewWithEvict(4, func(k, v interface{}) { order = append(order, k) })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Get("a")
+	l.PurgeOrdered()
+	if len(order) != 3 || order[0] != "b" || order[1] != "c" || order[2] != "a" {
+		t.Errorf("bad order: %v", order)
+	}
+	if l.Len() != 0 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+// test that PinFor keeps an entry through eviction pressure for a while
+func TestLRUPinFor(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("op", 1)
+	if !l.PinFor("op", 20*time.Millisecond) {
+		t.Fatalf("op should be pinned")
+	}
+	for i := 0; i < 10; i++ {
+		l.Add(i, i)
+	}
+	if !l.Contains("op") {
+		t.Errorf("op should survive while pinned")
+	}
+	time.Sleep(30 * time.Millisecond)
+	l.Add(10, 10)
+	if l.Contains("op") {
+		t.Errorf("op should be evicted once the pin lapses")
+	}
+}
+
+// test that the trace sink sees operations in order
+func TestLRUTrace(t *testing.T) {
+	var trace []string
+	l, err := NewWithTrace(4, func(op string, key interface{}) {
+		trace = append(trace, fmt.Sprintf("%s %v", op, key))
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Get(1)
+	l.Get(2)
+	l.Peek(1)
+	l.GetOrLoad(3, func() (interface{}, error) { return 3, nil })
+	l.Remove(1)
+
+	want := []string{"add 1", "get 1", "get 2", "get 3", "remove 1"}
+	if len(trace) != len(want) {
+		t.Fatalf("bad trace: %v", trace)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Errorf("bad trace: %v", trace)
+		}
+	}
+}
+
+// test that AddManyMode handles oversized batches as asked
+func TestLRUAddManyMode(t *testing.T) {
+	batch := make([]KV, 6)
+	for i := range batch {
+		batch[i] = KV{Key: i, Value: i}
+	}
+	for _, tc := range []struct {
+		mode OverflowMode
+		err  error
+		keys []interface{}
+	}{
+		{OverflowKeepLast, nil, []interface{}{2, 3, 4, 5}},
+		{OverflowKeepFirst, nil, []interface{}{0, 1, 2, 3}},
+		{OverflowReject, ErrBatchTooLarge, []interface{}{"old"}},
+	} {
+		l, err := New(4)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		l.Add("old", 0)
+		if err := l.AddManyMode(batch, tc.mode); err != tc.err {
+			t.Errorf("mode %d: bad err: %v", tc.mode, err)
+		}
+		keys := l.Keys()
+		if len(keys) != len(tc.keys) {
+			t.Fatalf("mode %d: bad keys: %v", tc.mode, keys)
+		}
+		for i := range keys {
+			if keys[i] != tc.keys[i] {
+				t.Errorf("mode %d: bad keys: %v", tc.mode, keys)
+			}
+		}
+		if err := l.AddManyMode(batch[:2], tc.mode); err != nil {
+			t.Errorf("mode %d: batch that fits should be added: %v", tc.mode, err)
+		}
+	}
+}
+
+// test that Touch promotes an entry without counting a lookup
+func TestLRUTouch(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.Touch(1) {
+		t.Fatalf("should contain 1")
+	}
+	if l.Touch(3) {
+		t.Fatalf("should not contain 3")
+	}
+	l.Add(3, 3)
+	if !l.Contains(1) || l.Contains(2) {
+		t.Errorf("touched entry should be kept: %v", l.Keys())
+	}
+	if s := l.Stats(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("bad stats: %+v", s)
+	}
+}
+
+// test that Get on a missing key stores the default value
+func TestLRUWithDefault(t *testing.T) {
+	l, err := NewWithDefault(2, func(k interface{}) interface{} {
+		return k.(int) * 10
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, ok := l.Peek(1); ok || l.Contains(1) {
+		t.Fatalf("Peek and Contains should not create entries")
+	}
+	if v, ok := l.Get(1); !ok || v != 10 {
+		t.Fatalf("bad default: %v %v", v, ok)
+	}
+	l.Add(1, 11)
+	if v, ok := l.Get(1); !ok || v != 11 {
+		t.Fatalf("default should be a normal entry: %v %v", v, ok)
+	}
+	l.Get(2)
+	l.Get(3)
+	if l.Len() != 2 || l.Contains(1) {
+		t.Errorf("default should evict like an add: %v", l.Keys())
+	}
+	if s := l.Stats(); s.Hits != 1 || s.Misses != 3 {
+		t.Errorf("bad stats: %+v", s)
+	}
+}
+
+// test that a cache in normal use stays consistent
+func TestLRUConsistent(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 16; i++ {
+		l.Add(i, i)
+		l.Get(i - 2)
+		if i%3 == 0 {
+			l.Remove(i - 1)
+		}
+		if !l.Consistent() {
+			t.Fatalf("should be consistent after %d", i)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package lru
+
+// NopCache is a cache that stores nothing: Add is a no-op, every lookup
+// misses and Len is always 0. It satisfies simplelru.LRUCache, so it can
+// stand in for a real cache when caching is disabled by configuration or
+// in tests. Its zero value is ready to use and safe for concurrent use.
+type NopCache struct{}
+
+// Add does nothing and reports no eviction.
+func (NopCache) Add(key, value interface{}) bool { return false }
+
+// Get always misses.
+func (NopCache) Get(key interface{}) (interface{}, bool) { return nil, false }
+
+// Contains always reports false.
+func (NopCache) Contains(key interface{}) bool { return false }
+
+// Peek always misses.
+func (NopCache) Peek(key interface{}) (interface{}, bool) { return nil, false }
+
+// Remove does nothing and reports the key was not contained.
+func (NopCache) Remove(key interface{}) bool { return false }
+
+// RemoveOldest finds nothing to remove.
+func (NopCache) RemoveOldest() (interface{}, interface{}, bool) { return nil, nil, false }
+
+// GetOldest finds nothing.
+func (NopCache) GetOldest() (interface{}, interface{}, bool) { return nil, nil, false }
+
+// Keys returns no keys.
+func (NopCache) Keys() []interface{} { return nil }
+
+// Len is always 0.
+func (NopCache) Len() int { return 0 }
+
+// Purge does nothing.
+func (NopCache) Purge() {}
+
+// Resize does nothing and evicts nothing.
+func (NopCache) Resize(int) int { return 0 }
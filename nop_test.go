@@ -0,0 +1,47 @@
+package lru
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/caser789/go-lru/simplelru"
+)
+
+func TestNopCache(t *testing.T) {
+	var c simplelru.LRUCache = NopCache{}
+
+	if c.Add(1, 1) {
+		t.Errorf("should not evict")
+	}
+	if _, ok := c.Get(1); ok || c.Contains(1) {
+		t.Errorf("should always miss")
+	}
+	if _, ok := c.Peek(1); ok || c.Remove(1) {
+		t.Errorf("should hold nothing")
+	}
+	if _, _, ok := c.GetOldest(); ok {
+		t.Errorf("should have no oldest")
+	}
+	if _, _, ok := c.RemoveOldest(); ok {
+		t.Errorf("should have no oldest")
+	}
+	c.Purge()
+	if c.Len() != 0 || len(c.Keys()) != 0 || c.Resize(8) != 0 {
+		t.Errorf("should be empty")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Add(i, i)
+			c.Get(i)
+		}(i)
+	}
+	wg.Wait()
+
+	if n := testing.AllocsPerRun(100, func() { c.Get(1) }); n != 0 {
+		t.Errorf("should not allocate: %v", n)
+	}
+}
@@ -0,0 +1,153 @@
+package lru
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Policy decides the eviction order of a PolicyCache. The cache keeps the
+// values itself and tells the policy about every key it tracks: Add when a
+// key is inserted, Touch when it is read or updated, and Remove when it
+// leaves the cache for any reason, including eviction. Victim is asked for
+// the key to evict once the cache is over capacity; it is only called
+// while the policy tracks at least one key, and should return one of them.
+// If Victim returns a key the cache does not hold, the cache tells the
+// policy to Remove it and asks again, a few times, before evicting some
+// other key of its own choosing, so it never grows past its size. A
+// Policy is always called under the cache's lock and so need not be
+// thread-safe itself.
+type Policy interface {
+	Victim() interface{}
+	Touch(key interface{})
+	Add(key interface{})
+	Remove(key interface{})
+}
+
+// victimRetries is how many times PolicyCache.Add asks the policy again
+// after it names a victim the cache does not hold.
+const victimRetries = 3
+
+// PolicyCache is a thread-safe fixed size cache whose eviction order is
+// delegated to a pluggable Policy.
+type PolicyCache struct {
+	size   int
+	items  map[interface{}]interface{}
+	policy Policy
+	lock   sync.RWMutex
+}
+
+// NewWithPolicy creates a PolicyCache of the given size that evicts the
+// keys chosen by p.
+func NewWithPolicy(size int, p Policy) (*PolicyCache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size")
+	}
+	if p == nil {
+		return nil, fmt.Errorf("invalid policy")
+	}
+	c := &PolicyCache{
+		size:   size,
+		items:  make(map[interface{}]interface{}),
+		policy: p,
+	}
+	return c, nil
+}
+
+// Add adds a value to the cache.  Returns true if an eviction occurred.
+func (c *PolicyCache) Add(key, value interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		c.items[key] = value
+		c.policy.Touch(key)
+		return false
+	}
+
+	c.items[key] = value
+	c.policy.Add(key)
+	if len(c.items) <= c.size {
+		return false
+	}
+	victim := c.policy.Victim()
+	for i := 0; i < victimRetries; i++ {
+		if _, ok := c.items[victim]; ok {
+			break
+		}
+		c.policy.Remove(victim)
+		victim = c.policy.Victim()
+	}
+	if _, ok := c.items[victim]; !ok {
+		// Fall back to any key other than the one just added
+		for k := range c.items {
+			if k != key {
+				victim = k
+				break
+			}
+		}
+	}
+	delete(c.items, victim)
+	c.policy.Remove(victim)
+	return true
+}
+
+// Get looks up a key's value from the cache.
+func (c *PolicyCache) Get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value, ok := c.items[key]
+	if ok {
+		c.policy.Touch(key)
+	}
+	return value, ok
+}
+
+// Peek returns the key value (or undefined if not found) without telling
+// the policy about the access.
+func (c *PolicyCache) Peek(key interface{}) (interface{}, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	value, ok := c.items[key]
+	return value, ok
+}
+
+// Contains checks if a key is in the cache, without telling the policy
+// about the access.
+func (c *PolicyCache) Contains(key interface{}) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// Remove removes the provided key from the cache, returning if the
+// key was contained.
+func (c *PolicyCache) Remove(key interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.items[key]; !ok {
+		return false
+	}
+	delete(c.items, key)
+	c.policy.Remove(key)
+	return true
+}
+
+// Len returns the number of items in the cache.
+func (c *PolicyCache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.items)
+}
+
+// Purge is used to completely clear the cache
+func (c *PolicyCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k := range c.items {
+		delete(c.items, k)
+		c.policy.Remove(k)
+	}
+}
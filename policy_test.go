@@ -0,0 +1,112 @@
+package lru
+
+import "testing"
+
+// fifoPolicy evicts keys in insertion order, ignoring accesses
+type fifoPolicy struct {
+	keys []interface{}
+}
+
+func (p *fifoPolicy) Victim() interface{}   { return p.keys[0] }
+func (p *fifoPolicy) Touch(key interface{}) {}
+func (p *fifoPolicy) Add(key interface{})   { p.keys = append(p.keys, key) }
+func (p *fifoPolicy) Remove(key interface{}) {
+	for i, k := range p.keys {
+		if k == key {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func TestPolicyCache(t *testing.T) {
+	p := &fifoPolicy{}
+	l, err := NewWithPolicy(2, p)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := NewWithPolicy(2, nil); err == nil {
+		t.Fatalf("should reject a nil policy")
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+
+	// FIFO ignores the Get above, so 1 is still the victim
+	if !l.Add(3, 3) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Contains(1) || !l.Contains(2) || !l.Contains(3) {
+		t.Errorf("1 should have been evicted")
+	}
+	if len(p.keys) != 2 {
+		t.Errorf("policy should have been told of the eviction: %v", p.keys)
+	}
+
+	if !l.Remove(2) || l.Remove(2) {
+		t.Errorf("2 should be removed exactly once")
+	}
+	if v, ok := l.Peek(3); !ok || v != 3 {
+		t.Errorf("3 should be set to 3: %v, %v", v, ok)
+	}
+
+	l.Purge()
+	if l.Len() != 0 || len(p.keys) != 0 {
+		t.Errorf("bad len: %v %v", l.Len(), p.keys)
+	}
+}
+
+// badPolicy names a victim the cache never held
+type badPolicy struct {
+	fifoPolicy
+}
+
+func (p *badPolicy) Victim() interface{} { return "unknown" }
+
+func TestPolicyCache_UnknownVictim(t *testing.T) {
+	l, err := NewWithPolicy(1, &badPolicy{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if !l.Add(2, 2) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Len() != 1 || l.Contains(1) || !l.Contains(2) {
+		t.Errorf("cache should evict another key instead of growing: %d", l.Len())
+	}
+}
+
+// stalePolicy names a key it was never told was removed, once
+type stalePolicy struct {
+	fifoPolicy
+}
+
+func (p *stalePolicy) Add(key interface{}) {
+	if len(p.keys) == 0 {
+		p.keys = append(p.keys, "stale")
+	}
+	p.fifoPolicy.Add(key)
+}
+
+func TestPolicyCache_StaleVictim(t *testing.T) {
+	p := &stalePolicy{}
+	l, err := NewWithPolicy(2, p)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	if l.Contains(1) || !l.Contains(2) || !l.Contains(3) {
+		t.Errorf("the policy's next victim should go once the stale key is dropped")
+	}
+	if len(p.keys) != 2 {
+		t.Errorf("policy should have been told to drop the stale key: %v", p.keys)
+	}
+}
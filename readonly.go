@@ -0,0 +1,40 @@
+package lru
+
+// ReadOnlyCache is a view of a Cache that only allows lookups. It shares
+// the underlying storage, so reads see live data, but offers no way to add,
+// remove or purge entries. Get still updates the recent-ness of the key.
+type ReadOnlyCache struct {
+	c *Cache
+}
+
+// ReadOnly returns a read-only view of the cache.
+func (c *Cache) ReadOnly() ReadOnlyCache {
+	return ReadOnlyCache{c: c}
+}
+
+// Get looks up a key's value from the cache.
+func (r ReadOnlyCache) Get(key interface{}) (interface{}, bool) {
+	return r.c.Get(key)
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (r ReadOnlyCache) Peek(key interface{}) (interface{}, bool) {
+	return r.c.Peek(key)
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (r ReadOnlyCache) Contains(key interface{}) bool {
+	return r.c.Contains(key)
+}
+
+// Len returns the number of items in the cache.
+func (r ReadOnlyCache) Len() int {
+	return r.c.Len()
+}
+
+// Keys returns a slice of the keys in the cache, from oldest to newest.
+func (r ReadOnlyCache) Keys() []interface{} {
+	return r.c.Keys()
+}
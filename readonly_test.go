@@ -0,0 +1,34 @@
+package lru
+
+import "testing"
+
+func TestReadOnlyCache(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	r := l.ReadOnly()
+	if r.Len() != 0 {
+		t.Errorf("bad len: %v", r.Len())
+	}
+
+	// Writes to the cache are visible through the view
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if v, ok := r.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if v, ok := r.Peek(2); !ok || v != 2 {
+		t.Errorf("2 should be set to 2: %v, %v", v, ok)
+	}
+	if !r.Contains(1) || r.Contains(3) {
+		t.Errorf("bad contains")
+	}
+	if keys := r.Keys(); len(keys) != 2 || keys[0] != 2 {
+		t.Errorf("Get through the view should update recent-ness: %v", keys)
+	}
+	if r.Len() != 2 {
+		t.Errorf("bad len: %v", r.Len())
+	}
+}
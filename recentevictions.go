@@ -0,0 +1,87 @@
+package lru
+
+import (
+	"time"
+
+	"github.com/caser789/go-lru/simplelru"
+)
+
+// defaultRecentEvictions is the number of records NewWithRecentEvictions
+// keeps when given no positive size
+const defaultRecentEvictions = 16
+
+// RemoveReason tells why an entry left the cache.
+type RemoveReason = simplelru.RemoveReason
+
+// The reasons an entry can leave the cache.
+const (
+	ReasonRemoved = simplelru.ReasonRemoved
+	ReasonEvicted = simplelru.ReasonEvicted
+	ReasonExpired = simplelru.ReasonExpired
+	ReasonPurged  = simplelru.ReasonPurged
+)
+
+// EvictionRecord describes an entry that left the cache.
+type EvictionRecord struct {
+	Key    interface{}
+	Reason RemoveReason
+	At     time.Time
+}
+
+// evictionRing holds the most recent eviction records, overwriting the
+// oldest once full
+type evictionRing struct {
+	records []EvictionRecord
+	next    int
+	full    bool
+}
+
+// NewWithRecentEvictions constructs a fixed size cache that remembers the
+// last n entries to leave it, with the reason and time, for
+// RecentEvictions to report. A non-positive n keeps a small default.
+func NewWithRecentEvictions(size, n int) (*Cache, error) {
+	if n <= 0 {
+		n = defaultRecentEvictions
+	}
+	c := &Cache{recent: &evictionRing{records: make([]EvictionRecord, n)}}
+	lru, err := simplelru.NewLRUWithRemoveCallback(size, c.onEvict, func(key, value interface{}, reason RemoveReason) {
+		c.recent.add(EvictionRecord{Key: key, Reason: reason, At: time.Now()})
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+// RecentEvictions returns the most recent entries to leave the cache,
+// oldest first, as a rolling window suitable for a debug endpoint. It is
+// empty unless the cache was constructed with NewWithRecentEvictions.
+func (c *Cache) RecentEvictions() []EvictionRecord {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.recent == nil {
+		return nil
+	}
+	return c.recent.snapshot()
+}
+
+// add records an eviction
+func (r *evictionRing) add(rec EvictionRecord) {
+	r.records[r.next] = rec
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// snapshot returns a copy of the records, oldest first
+func (r *evictionRing) snapshot() []EvictionRecord {
+	if !r.full {
+		return append([]EvictionRecord(nil), r.records[:r.next]...)
+	}
+	out := make([]EvictionRecord, 0, len(r.records))
+	out = append(out, r.records[r.next:]...)
+	return append(out, r.records[:r.next]...)
+}
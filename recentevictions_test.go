@@ -0,0 +1,77 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentEvictions(t *testing.T) {
+	l, err := NewWithRecentEvictions(2, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recs := l.RecentEvictions(); len(recs) != 0 {
+		t.Errorf("nothing evicted yet: %v", recs)
+	}
+
+	start := time.Now()
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Remove(2)
+	l.AddExpireAt(4, 4, time.Now().Add(-time.Second))
+	l.Get(4)
+	l.Add(5, 5)
+	l.Purge()
+
+	// Only the last three are kept, and purge order is unspecified
+	recs := l.RecentEvictions()
+	if len(recs) != 3 {
+		t.Fatalf("bad records: %v", recs)
+	}
+	if recs[0].Key != 4 || recs[0].Reason != ReasonExpired {
+		t.Errorf("bad record: %v", recs[0])
+	}
+	if recs[1].Reason != ReasonPurged || recs[2].Reason != ReasonPurged {
+		t.Errorf("bad records: %v", recs)
+	}
+	for _, r := range recs {
+		if r.At.Before(start) {
+			t.Errorf("bad timestamp: %v", r)
+		}
+	}
+}
+
+func TestRecentEvictions_Window(t *testing.T) {
+	l, err := NewWithRecentEvictions(1, 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.Add(i, i)
+	}
+	recs := l.RecentEvictions()
+	if len(recs) != defaultRecentEvictions {
+		t.Fatalf("bad window: %d", len(recs))
+	}
+	for i, r := range recs {
+		if r.Key != 99-defaultRecentEvictions+i || r.Reason != ReasonEvicted {
+			t.Errorf("bad record %d: %v", i, r)
+		}
+	}
+	if ReasonEvicted.String() != "evicted" {
+		t.Errorf("bad name: %v", ReasonEvicted)
+	}
+
+	plain, err := New(1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	plain.Add(1, 1)
+	plain.Add(2, 2)
+	if recs := plain.RecentEvictions(); recs != nil {
+		t.Errorf("should not be recorded: %v", recs)
+	}
+}
@@ -0,0 +1,163 @@
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SampledLRU is a thread-safe fixed size cache with approximate LRU
+// eviction, as used by Redis. Instead of keeping every entry in a list
+// ordered by recency, Get only stamps the entry with its access time, and
+// eviction samples a few random entries and evicts the least recently used
+// of those. Gets are much cheaper and run under a read lock, at the cost of
+// sometimes evicting an entry that is not the very oldest; more samples
+// give a closer approximation.
+type SampledLRU struct {
+	size    int
+	samples int
+	clock   uint64
+	items   map[interface{}]*sampledEntry
+	entries []*sampledEntry
+	rand    *rand.Rand
+	lock    sync.RWMutex
+}
+
+// sampledEntry is used to hold a value in a SampledLRU
+type sampledEntry struct {
+	key    interface{}
+	value  interface{}
+	access uint64 // logical time of the last access, updated atomically
+	index  int    // position in entries
+}
+
+// NewSampledLRU creates a SampledLRU of the given size that samples the
+// given number of entries on each eviction.
+func NewSampledLRU(size, samples int) (*SampledLRU, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size")
+	}
+	if samples <= 0 {
+		return nil, fmt.Errorf("invalid samples")
+	}
+	c := &SampledLRU{
+		size:    size,
+		samples: samples,
+		items:   make(map[interface{}]*sampledEntry),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	return c, nil
+}
+
+// Add adds a value to the cache.  Returns true if an eviction occurred.
+func (c *SampledLRU) Add(key, value interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		ent.value = value
+		c.touch(ent)
+		return false
+	}
+
+	evict := len(c.entries) >= c.size
+	if evict {
+		c.removeEntry(c.victim())
+	}
+	ent := &sampledEntry{key: key, value: value, index: len(c.entries)}
+	c.touch(ent)
+	c.items[key] = ent
+	c.entries = append(c.entries, ent)
+	return evict
+}
+
+// Get looks up a key's value from the cache, stamping it as recently used.
+func (c *SampledLRU) Get(key interface{}) (interface{}, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if ent, ok := c.items[key]; ok {
+		c.touch(ent)
+		return ent.value, true
+	}
+	return nil, false
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *SampledLRU) Peek(key interface{}) (interface{}, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if ent, ok := c.items[key]; ok {
+		return ent.value, true
+	}
+	return nil, false
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (c *SampledLRU) Contains(key interface{}) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// Remove removes the provided key from the cache, returning if the
+// key was contained.
+func (c *SampledLRU) Remove(key interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ent, ok := c.items[key]; ok {
+		c.removeEntry(ent)
+		return true
+	}
+	return false
+}
+
+// Len returns the number of items in the cache.
+func (c *SampledLRU) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.entries)
+}
+
+// Purge is used to completely clear the cache
+func (c *SampledLRU) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = make(map[interface{}]*sampledEntry)
+	c.entries = nil
+}
+
+// touch stamps an entry with the next logical time
+func (c *SampledLRU) touch(ent *sampledEntry) {
+	atomic.StoreUint64(&ent.access, atomic.AddUint64(&c.clock, 1))
+}
+
+// victim returns the least recently used of a random sample of entries.
+// The cache must not be empty.
+func (c *SampledLRU) victim() *sampledEntry {
+	var oldest *sampledEntry
+	for i := 0; i < c.samples; i++ {
+		ent := c.entries[c.rand.Intn(len(c.entries))]
+		if oldest == nil || atomic.LoadUint64(&ent.access) < atomic.LoadUint64(&oldest.access) {
+			oldest = ent
+		}
+	}
+	return oldest
+}
+
+// removeEntry deletes an entry, moving the last entry into its slot
+func (c *SampledLRU) removeEntry(ent *sampledEntry) {
+	last := c.entries[len(c.entries)-1]
+	c.entries[ent.index] = last
+	last.index = ent.index
+	c.entries[len(c.entries)-1] = nil
+	c.entries = c.entries[:len(c.entries)-1]
+	delete(c.items, ent.key)
+}
@@ -0,0 +1,96 @@
+package lru
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func BenchmarkSampledLRU_Rand(b *testing.B) {
+	l, err := NewSampledLRU(8192, 5)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		trace[i] = rand.Int63() % 32768
+	}
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			_, ok := l.Get(trace[i])
+			if ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
+}
+
+func TestSampledLRU_RandomOps(t *testing.T) {
+	size := 128
+	l, err := NewSampledLRU(size, 5)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	n := 200000
+	for i := 0; i < n; i++ {
+		key := rand.Int63() % 512
+		r := rand.Int63()
+		switch r % 3 {
+		case 0:
+			l.Add(key, key)
+		case 1:
+			l.Get(key)
+		case 2:
+			l.Remove(key)
+		}
+
+		if l.Len() > size || len(l.items) != len(l.entries) {
+			t.Fatalf("bad: len: %d items: %d", l.Len(), len(l.items))
+		}
+	}
+	for i, ent := range l.entries {
+		if ent.index != i || l.items[ent.key] != ent {
+			t.Fatalf("bad entry at %d: %+v", i, ent)
+		}
+	}
+}
+
+func TestSampledLRU(t *testing.T) {
+	// Sampling every slot many times makes eviction exact
+	l, err := NewSampledLRU(4, 64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Get(0)
+	if !l.Add(4, 4) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Contains(1) || !l.Contains(0) {
+		t.Errorf("1 should have been evicted as the least recently used")
+	}
+	if v, ok := l.Peek(4); !ok || v != 4 {
+		t.Errorf("4 should be set to 4: %v, %v", v, ok)
+	}
+
+	if !l.Remove(4) || l.Remove(4) {
+		t.Errorf("4 should be removed exactly once")
+	}
+	l.Purge()
+	if l.Len() != 0 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
@@ -3,23 +3,125 @@ package simplelru
 import (
 	"container/list"
 	"errors"
+	"sort"
+	"time"
 )
 
 // EvictCallback is used to get a callback when a cache entry is evicted
 type EvictCallback func(key interface{}, value interface{})
 
+// AddCallback is used to get a callback when a cache entry is inserted or
+// updated, where isUpdate tells a value refresh from a new key
+type AddCallback func(key interface{}, value interface{}, isUpdate bool)
+
+// RemoveReason tells why an entry left the cache
+type RemoveReason int
+
+const (
+	// ReasonRemoved is an explicit removal by the caller
+	ReasonRemoved RemoveReason = iota
+	// ReasonEvicted is an eviction to make room
+	ReasonEvicted
+	// ReasonExpired is a stale entry reclaimed on lookup, either expired
+	// or from a previous generation
+	ReasonExpired
+	// ReasonPurged is a removal by Purge
+	ReasonPurged
+)
+
+// String returns the name of the reason
+func (r RemoveReason) String() string {
+	switch r {
+	case ReasonRemoved:
+		return "removed"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonExpired:
+		return "expired"
+	case ReasonPurged:
+		return "purged"
+	}
+	return "unknown"
+}
+
+// RemoveCallback is used to get a callback when a cache entry leaves the
+// cache, along with the reason it did
+type RemoveCallback func(key interface{}, value interface{}, reason RemoveReason)
+
+// CanEvictFunc is used to veto the eviction of a cache entry. Returning
+// false keeps the entry and moves on to the next-oldest one.
+type CanEvictFunc func(key interface{}, value interface{}) bool
+
 // LRU implements a non-thread safe fixed size LRU cache
 type LRU struct {
-	size      int
-	evictList *list.List
-	items     map[interface{}]*list.Element
-	onEvict   EvictCallback
+	size       int
+	evictBatch int
+	evictList  *list.List
+	items      map[interface{}]*list.Element
+	onEvict    EvictCallback
+	canEvict   CanEvictFunc
+	paused     bool
+	promoteAt  int
+	minDwell   time.Duration
+	minFresh   time.Duration
+	rejectNil  bool
+	generation uint64
+	onAdd      AddCallback
+	onRemove   RemoveCallback
+	dirty      map[interface{}]struct{}
+	ages       ageStats
+	overflow   overflowStats
+	tiers      map[int]int // entries per priority, nil until one is set
+	tierOrder  []int       // priorities in tiers, lowest first
+	versions   uint64      // last version given to a written value
+	timedPins  bool        // set once PinFor has been used
+	accesses   bool        // set by TrackAccess
+}
+
+// overflowStats counts inserts that left the cache above its size because
+// no entry could be evicted
+type overflowStats struct {
+	max    int
+	events int64
+}
+
+// ageStats accumulates how long evicted entries had been resident
+type ageStats struct {
+	count         int
+	min, max, sum time.Duration
+}
+
+// KV is a key-value pair as held by the cache
+type KV struct {
+	Key   interface{}
+	Value interface{}
 }
 
 // entry is used to hold a value in the evictList
 type entry struct {
-	key   interface{}
-	value interface{}
+	key         interface{}
+	value       interface{}
+	expiresAt   time.Time
+	added       time.Time
+	hits        int
+	gen         uint64
+	uses        int
+	priority    int
+	version     uint64
+	pinnedUntil time.Time
+	accessed    time.Time // last added or read, only kept with TrackAccess
+}
+
+// expired reports whether the entry has a deadline that has passed. The
+// clock is only read for entries that have a deadline.
+func (e *entry) expired() bool {
+	return !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt)
+}
+
+// stale reports whether an entry should be treated as missing, either
+// because it expired or because it predates the current generation
+func (c *LRU) stale(e *entry) bool {
+	return e.gen != c.generation || e.expired()
 }
 
 // NewLRU constructs an LRU of the given size
@@ -36,73 +138,550 @@ func NewLRU(size int, onEvict EvictCallback) (*LRU, error) {
 	return c, nil
 }
 
+// NewLRUWithEvictBatch constructs an LRU of the given size that evicts in
+// batches. The length may grow past size by up to batch entries; once an
+// Add pushes it over that high-water mark of size+batch, entries are removed
+// from the tail until it is back to size, so the following batch Adds do
+// not evict at all. A batch of 0 behaves like NewLRU.
+func NewLRUWithEvictBatch(size, batch int, onEvict EvictCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	if batch < 0 {
+		return nil, errors.New("Must provide a non-negative batch")
+	}
+	c.evictBatch = batch
+	return c, nil
+}
+
+// NewLRUWithCanEvict constructs an LRU of the given size whose evictions
+// are subject to canEvict. Entries it refuses are skipped in favour of the
+// next-oldest one; if no entry may be evicted the cache temporarily grows
+// beyond size rather than rejecting the Add.
+func NewLRUWithCanEvict(size int, onEvict EvictCallback, canEvict CanEvictFunc) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.canEvict = canEvict
+	return c, nil
+}
+
+// NewLRUWithPromoteThreshold constructs an LRU of the given size in which
+// Get only moves an entry to the front once it has been read k times since
+// it was last promoted, so a single scan cannot flush the hot entries. Add
+// still promotes immediately. A k of 1 behaves like NewLRU.
+func NewLRUWithPromoteThreshold(size, k int, onEvict EvictCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	if k < 1 {
+		return nil, errors.New("Must provide a positive promote threshold")
+	}
+	c.promoteAt = k
+	return c, nil
+}
+
+// NewLRUWithMinResidency constructs an LRU of the given size in which an
+// entry cannot be evicted until it has been in the cache for at least
+// minDwell. Eviction skips entries that are too young in favour of older
+// ones; if every entry is too young the cache temporarily grows beyond
+// size rather than rejecting the Add.
+func NewLRUWithMinResidency(size int, minDwell time.Duration, onEvict EvictCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.minDwell = minDwell
+	return c, nil
+}
+
+// NewLRUWithFreshnessPromotion constructs an LRU of the given size in
+// which Get only marks an entry as recently used if it has at least
+// minRemaining left before its deadline. Entries close to expiry are still
+// returned but keep their place, so they drift towards eviction instead of
+// being kept alive by reads. Entries without a deadline always promote.
+func NewLRUWithFreshnessPromotion(size int, minRemaining time.Duration, onEvict EvictCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.minFresh = minRemaining
+	return c, nil
+}
+
+// NewLRUWithOnAdd constructs an LRU of the given size that calls onAdd
+// after every insert or update of an entry.
+func NewLRUWithOnAdd(size int, onEvict EvictCallback, onAdd AddCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.onAdd = onAdd
+	return c, nil
+}
+
+// NewLRUWithRemoveCallback constructs an LRU of the given size that calls
+// onRemove, after onEvict, whenever an entry leaves the cache, telling
+// evictions apart from removals, expiry and purges.
+func NewLRUWithRemoveCallback(size int, onEvict EvictCallback, onRemove RemoveCallback) (*LRU, error) {
+	c, err := NewLRU(size, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.onRemove = onRemove
+	return c, nil
+}
+
+// TrackDirty starts recording which keys are added or updated, for
+// DirtyKeys to report. Keys stop being dirty once ClearDirty is called or
+// they leave the cache.
+func (c *LRU) TrackDirty() {
+	if c.dirty == nil {
+		c.dirty = make(map[interface{}]struct{})
+	}
+}
+
+// TrackAccess starts recording when each entry was last added, updated or
+// read with Get, for RemoveAccessedBefore. It costs a clock read per Get.
+// Entries already in the cache count as accessed now.
+func (c *LRU) TrackAccess() {
+	if c.accesses {
+		return
+	}
+	c.accesses = true
+	now := time.Now()
+	for _, ent := range c.items {
+		ent.Value.(*entry).accessed = now
+	}
+}
+
+// DirtyKeys returns the keys added or updated since the last ClearDirty
+// that are still in the cache, in no particular order.
+func (c *LRU) DirtyKeys() []interface{} {
+	keys := make([]interface{}, 0, len(c.dirty))
+	for k := range c.dirty {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ClearDirty marks every key as clean.
+func (c *LRU) ClearDirty() {
+	if c.dirty != nil {
+		c.dirty = make(map[interface{}]struct{})
+	}
+}
+
+// RejectNilKeys makes every add of a nil key a no-op, rather than storing
+// a value under the nil interface key.
+func (c *LRU) RejectNilKeys() {
+	c.rejectNil = true
+}
+
 // Purge is used to completely clear the cache
 func (c *LRU) Purge() {
 	for k, v := range c.items {
-		if c.onEvict != nil {
-			c.onEvict(k, v.Value.(*entry).value)
-		}
+		c.purged(v.Value.(*entry))
 		delete(c.items, k)
 	}
+	c.clear()
+}
+
+// PurgeOrdered clears the cache like Purge, but calls the callbacks in
+// strict order from the oldest entry to the newest.
+func (c *LRU) PurgeOrdered() {
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		c.purged(ent.Value.(*entry))
+	}
+	c.items = make(map[interface{}]*list.Element)
+	c.clear()
+}
+
+// purged runs the callbacks for an entry removed by a purge
+func (c *LRU) purged(kv *entry) {
+	if c.onEvict != nil {
+		c.onEvict(kv.key, kv.value)
+	}
+	if c.onRemove != nil {
+		c.onRemove(kv.key, kv.value, ReasonPurged)
+	}
+}
+
+// clear resets the list and per-entry bookkeeping once the items are gone
+func (c *LRU) clear() {
 	c.evictList.Init()
+	if c.dirty != nil {
+		c.dirty = make(map[interface{}]struct{})
+	}
+	c.tiers = nil
+	c.tierOrder = nil
 }
 
 // Add adds a value to the cache.  Returns true if an eviction occurred.
 func (c *LRU) Add(key, value interface{}) bool {
+	return c.add(key, value, time.Time{}, 0, false)
+}
+
+// AddExpireAt adds a value to the cache that expires at the given deadline.
+// Once expired, the entry is treated as missing by Get, Peek and Contains;
+// a deadline in the past makes it expired immediately. Returns true if an
+// eviction occurred.
+func (c *LRU) AddExpireAt(key, value interface{}, deadline time.Time) bool {
+	return c.add(key, value, deadline, 0, false)
+}
+
+// add is used to add or update an entry with the given deadline, where a
+// zero deadline never expires. A new entry goes in the given priority
+// tier, and if tiered is set it may be evicted itself when it is alone in
+// the lowest tier.
+func (c *LRU) add(key, value interface{}, expiresAt time.Time, priority int, tiered bool) bool {
+	if key == nil && c.rejectNil {
+		return false
+	}
+
 	// Check for existing item
 	if ent, ok := c.items[key]; ok {
 		c.evictList.MoveToFront(ent)
-		ent.Value.(*entry).value = value
+		kv := ent.Value.(*entry)
+		isUpdate := !c.stale(kv)
+		kv.value = value
+		kv.expiresAt = expiresAt
+		kv.gen = c.generation
+		kv.hits = 0
+		c.added(kv, isUpdate)
 		return false
 	}
 
 	// Add new item
-	ent := &entry{key, value}
+	if priority != 0 && c.tiers == nil {
+		c.initTiers()
+	}
+	ent := &entry{key: key, value: value, expiresAt: expiresAt, added: time.Now(), gen: c.generation, priority: priority}
 	entry := c.evictList.PushFront(ent)
 	c.items[key] = entry
+	if c.tiers != nil {
+		c.countTier(priority)
+	}
+
+	// A new entry alone in the lowest tier is the first candidate, so it
+	// is announced before it can be evicted
+	skip := entry
+	if tiered && c.tiers != nil && c.tierOrder[0] == priority && c.tiers[priority] == 1 {
+		skip = nil
+		c.added(ent, false)
+	}
+
+	// Verify size not exceeded by more than the batch, freeing a whole
+	// batch at once
+	evict := false
+	if !c.paused && c.evictList.Len() > c.size+c.evictBatch {
+		for c.evictList.Len() > c.size && c.removeOldest(skip) {
+			evict = true
+		}
+		c.checkOverflow()
+	}
+	if skip != nil {
+		c.added(ent, false)
+	}
+	return evict
+}
+
+// AddWithPriority adds a value to the cache in the given priority tier.
+// Eviction takes the oldest entry of the lowest tier first, even if
+// entries in higher tiers are older; within a tier, LRU order applies, so
+// a new key alone in a tier below every other entry is itself evicted
+// when the cache is full. Get promotes an entry within its own tier.
+// Entries added with Add are in tier 0, and re-adding a key with Add keeps
+// its tier. Returns true if an eviction occurred.
+func (c *LRU) AddWithPriority(key, value interface{}, priority int) bool {
+	evict := c.add(key, value, time.Time{}, priority, true)
+	if ent, ok := c.items[key]; ok {
+		c.setPriority(ent.Value.(*entry), priority)
+	}
+	return evict
+}
 
-	evict := c.evictList.Len() > c.size
-	// Verify size not exceeded
-	if evict {
-		c.removeOldest()
+// setPriority moves an entry to a priority tier, keeping the tier counts
+func (c *LRU) setPriority(kv *entry, priority int) {
+	if kv.priority == priority {
+		return
+	}
+	if c.tiers == nil {
+		c.initTiers()
+	}
+	c.untier(kv)
+	c.countTier(priority)
+	kv.priority = priority
+}
+
+// initTiers starts counting tiers, with every entry so far in tier 0
+func (c *LRU) initTiers() {
+	c.tiers = make(map[int]int)
+	for i := c.evictList.Len(); i > 0; i-- {
+		c.countTier(0)
+	}
+}
+
+// countTier adds an entry to the count of a priority tier, keeping
+// tierOrder sorted when the tier is new
+func (c *LRU) countTier(priority int) {
+	if c.tiers[priority]++; c.tiers[priority] > 1 {
+		return
+	}
+	i := sort.SearchInts(c.tierOrder, priority)
+	c.tierOrder = append(c.tierOrder, 0)
+	copy(c.tierOrder[i+1:], c.tierOrder[i:])
+	c.tierOrder[i] = priority
+}
+
+// untier removes an entry from the count of its priority tier
+func (c *LRU) untier(kv *entry) {
+	if c.tiers[kv.priority]--; c.tiers[kv.priority] == 0 {
+		delete(c.tiers, kv.priority)
+		i := sort.SearchInts(c.tierOrder, kv.priority)
+		c.tierOrder = append(c.tierOrder[:i], c.tierOrder[i+1:]...)
+	}
+}
+
+// AddCold adds a value to the back of the eviction list, so that it is
+// the first entry to be evicted unless it is accessed. Room is made before
+// the insert so the new entry itself is never the victim. An existing key
+// has its value updated without changing its position. Returns true if an
+// eviction occurred.
+func (c *LRU) AddCold(key, value interface{}) bool {
+	if key == nil && c.rejectNil {
+		return false
 	}
+
+	// Check for existing item
+	if ent, ok := c.items[key]; ok {
+		kv := ent.Value.(*entry)
+		isUpdate := !c.stale(kv)
+		kv.value = value
+		kv.expiresAt = time.Time{}
+		kv.gen = c.generation
+		c.added(kv, isUpdate)
+		return false
+	}
+
+	// Make room for the new item
+	evict := false
+	if !c.paused && c.evictList.Len() >= c.size+c.evictBatch {
+		for c.evictList.Len() >= c.size && c.removeOldest(nil) {
+			evict = true
+		}
+	}
+
+	// Add new item
+	ent := &entry{key: key, value: value, added: time.Now(), gen: c.generation}
+	c.items[key] = c.evictList.PushBack(ent)
+	if c.tiers != nil {
+		c.countTier(0)
+	}
+	if !c.paused {
+		c.checkOverflow()
+	}
+	c.added(ent, false)
 	return evict
 }
 
 // Get looks up a key's value from the cache.
+// Expired entries are removed and reported as missing.
 func (c *LRU) Get(key interface{}) (value interface{}, ok bool) {
 	if ent, ok := c.items[key]; ok {
-		c.evictList.MoveToFront(ent)
-		if ent.Value.(*entry) == nil {
+		kv := ent.Value.(*entry)
+		if kv == nil {
 			return nil, false
 		}
-		return ent.Value.(*entry).value, true
+		if c.stale(kv) {
+			c.removeElement(ent, ReasonExpired)
+			return nil, false
+		}
+		if c.accesses {
+			kv.accessed = time.Now()
+		}
+		c.promote(ent)
+		return kv.value, true
 	}
 	return
 }
 
+// Acquire looks up a key's value like Get and pins the entry against
+// eviction until the returned release is called. Acquires nest, and release
+// may safely be called more than once. An entry pinned while the cache is
+// full makes it overflow rather than be evicted.
+func (c *LRU) Acquire(key interface{}) (value interface{}, release func(), ok bool) {
+	value, ok = c.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+	kv := c.items[key].Value.(*entry)
+	kv.uses++
+	released := false
+	return value, func() {
+		if !released {
+			released = true
+			kv.uses--
+		}
+	}, true
+}
+
+// PinFor protects key from eviction for d, after which it is an eviction
+// candidate like any other. Pinning an entry again replaces its deadline.
+// While pinned entries fill the cache it overflows rather than evict them.
+// Returns whether the key was contained.
+func (c *LRU) PinFor(key interface{}, d time.Duration) bool {
+	ent, ok := c.items[key]
+	if !ok || c.stale(ent.Value.(*entry)) {
+		return false
+	}
+	ent.Value.(*entry).pinnedUntil = time.Now().Add(d)
+	c.timedPins = true
+	return true
+}
+
+// promote moves an element to the front once it has had enough hits,
+// unless it is too close to expiry to be worth keeping
+func (c *LRU) promote(ent *list.Element) {
+	kv := ent.Value.(*entry)
+	if c.minFresh > 0 && !kv.expiresAt.IsZero() && time.Until(kv.expiresAt) < c.minFresh {
+		return
+	}
+	if c.promoteAt > 1 {
+		if kv.hits++; kv.hits < c.promoteAt {
+			return
+		}
+		kv.hits = 0
+	}
+	c.evictList.MoveToFront(ent)
+}
+
 // Contains check if a key is in the cache, without updating the recent-ness
 // or deleting it for being stale.
 func (c *LRU) Contains(key interface{}) (ok bool) {
-	_, ok = c.items[key]
-	return ok
+	ent, ok := c.items[key]
+	return ok && !c.stale(ent.Value.(*entry))
 }
 
 // Peek returns the key value (or undefined if not found) without updating
 // the "recently used"-ness of the key.
 func (c *LRU) Peek(key interface{}) (value interface{}, ok bool) {
 	var ent *list.Element
-	if ent, ok = c.items[key]; ok {
+	if ent, ok = c.items[key]; ok && !c.stale(ent.Value.(*entry)) {
 		return ent.Value.(*entry).value, true
 	}
-	return nil, ok
+	return nil, false
+}
+
+// Update applies fn to the value of key without updating its recent-ness.
+// If fn returns keep false the entry is removed, otherwise its value is
+// replaced, keeping its position and deadline. Returns whether the key was
+// contained; fn is not called for a missing key.
+func (c *LRU) Update(key interface{}, fn func(old interface{}) (new interface{}, keep bool)) bool {
+	ent, ok := c.items[key]
+	if !ok || c.stale(ent.Value.(*entry)) {
+		return false
+	}
+	kv := ent.Value.(*entry)
+	value, keep := fn(kv.value)
+	if !keep {
+		c.removeElement(ent, ReasonRemoved)
+		return true
+	}
+	kv.value = value
+	c.added(kv, true)
+	return true
+}
+
+// Version returns the version of key's current value without updating its
+// recent-ness. Every write of a value, by any add method or Update, gives
+// it a new version greater than any before it in this cache, so a version
+// identifies one write even across removal and re-insertion of the key.
+func (c *LRU) Version(key interface{}) (version uint64, ok bool) {
+	if ent, ok := c.items[key]; ok && !c.stale(ent.Value.(*entry)) {
+		return ent.Value.(*entry).version, true
+	}
+	return 0, false
+}
+
+// Rank returns the position of key in the eviction list, where 0 is the
+// most recently used entry and Len()-1 the next to be evicted, without
+// updating its recent-ness. It walks the list, taking O(rank) time.
+func (c *LRU) Rank(key interface{}) (rank int, ok bool) {
+	target, ok := c.items[key]
+	if !ok || c.stale(target.Value.(*entry)) {
+		return 0, false
+	}
+	for ent := c.evictList.Front(); ent != target; ent = ent.Next() {
+		rank++
+	}
+	return rank, true
 }
 
 // Remove removes the provided key from the cache, returning if the
 // key was contained.
 func (c *LRU) Remove(key interface{}) bool {
 	if ent, ok := c.items[key]; ok {
-		c.removeElement(ent)
+		c.removeElement(ent, ReasonRemoved)
+		return true
+	}
+	return false
+}
+
+// Take removes the provided key from the cache without calling the
+// eviction or remove callbacks, returning its value and its deadline, the
+// zero time if it has none. It is for moving an entry elsewhere, where the
+// value stays in use. A stale entry is treated as missing and left alone.
+func (c *LRU) Take(key interface{}) (value interface{}, expiresAt time.Time, ok bool) {
+	ent, ok := c.items[key]
+	if !ok || c.stale(ent.Value.(*entry)) {
+		return nil, time.Time{}, false
+	}
+	kv := c.unlink(ent)
+	return kv.value, kv.expiresAt, true
+}
+
+// RemoveAddedBefore removes every entry first inserted before t, whatever
+// its deadline, returning the number removed. Updating an existing key does
+// not change when it was inserted.
+func (c *LRU) RemoveAddedBefore(t time.Time) (removed int) {
+	for ent := c.evictList.Back(); ent != nil; {
+		prev := ent.Prev()
+		if ent.Value.(*entry).added.Before(t) {
+			c.removeElement(ent, ReasonRemoved)
+			removed++
+		}
+		ent = prev
+	}
+	return removed
+}
+
+// RemoveAccessedBefore removes every entry last added, updated or read
+// before t, returning the number removed. It removes nothing unless
+// TrackAccess has been called.
+func (c *LRU) RemoveAccessedBefore(t time.Time) (removed int) {
+	if !c.accesses {
+		return 0
+	}
+	for ent := c.evictList.Back(); ent != nil; {
+		prev := ent.Prev()
+		if ent.Value.(*entry).accessed.Before(t) {
+			c.removeElement(ent, ReasonRemoved)
+			removed++
+		}
+		ent = prev
+	}
+	return removed
+}
+
+// Demote moves the provided key to the back of the eviction list, making
+// it the next entry to be evicted, returning if the key was contained.
+func (c *LRU) Demote(key interface{}) bool {
+	if ent, ok := c.items[key]; ok {
+		c.evictList.MoveToBack(ent)
 		return true
 	}
 	return false
@@ -112,7 +691,7 @@ func (c *LRU) Remove(key interface{}) bool {
 func (c *LRU) RemoveOldest() (interface{}, interface{}, bool) {
 	ent := c.evictList.Back()
 	if ent != nil {
-		c.removeElement(ent)
+		c.removeElement(ent, ReasonRemoved)
 		kv := ent.Value.(*entry)
 		return kv.key, kv.value, true
 	}
@@ -129,13 +708,56 @@ func (c *LRU) GetOldest() (interface{}, interface{}, bool) {
 	return nil, nil, false
 }
 
+// PeekN returns up to n entries from the most recently used end of the
+// cache, newest first, without updating their "recently used"-ness.
+// Expired entries are skipped.
+func (c *LRU) PeekN(n int) []KV {
+	if n > c.evictList.Len() {
+		n = c.evictList.Len()
+	}
+	if n <= 0 {
+		return nil
+	}
+	kvs := make([]KV, 0, n)
+	for ent := c.evictList.Front(); ent != nil && len(kvs) < n; ent = ent.Next() {
+		if kv := ent.Value.(*entry); !c.stale(kv) {
+			kvs = append(kvs, KV{kv.key, kv.value})
+		}
+	}
+	return kvs
+}
+
+// GetOldestEvictable returns the oldest entry that eviction would actually
+// pick, skipping entries that may not currently be evicted.
+func (c *LRU) GetOldestEvictable() (interface{}, interface{}, bool) {
+	ent := c.victim(nil)
+	if ent != nil {
+		kv := ent.Value.(*entry)
+		return kv.key, kv.value, true
+	}
+	return nil, nil, false
+}
+
 // Keys returns a slice of the keys in the cache, from oldest to newest.
+// Expired entries and entries from before the last Bump are skipped.
 func (c *LRU) Keys() []interface{} {
-	keys := make([]interface{}, len(c.items))
-	i := 0
+	keys := make([]interface{}, 0, len(c.items))
 	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
-		keys[i] = ent.Value.(*entry).key
-		i++
+		if kv := ent.Value.(*entry); !c.stale(kv) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// KeysNewestFirst returns a slice of the keys in the cache, from newest to
+// oldest. Stale entries are skipped as in Keys.
+func (c *LRU) KeysNewestFirst() []interface{} {
+	keys := make([]interface{}, 0, len(c.items))
+	for ent := c.evictList.Front(); ent != nil; ent = ent.Next() {
+		if kv := ent.Value.(*entry); !c.stale(kv) {
+			keys = append(keys, kv.key)
+		}
 	}
 	return keys
 }
@@ -145,33 +767,210 @@ func (c *LRU) Len() int {
 	return c.evictList.Len()
 }
 
-// removeOldest removes the oldest item from the cache.
-func (c *LRU) removeOldest() {
-	ent := c.evictList.Back()
-	if ent != nil {
-		c.removeElement(ent)
+// Consistent reports whether the key index and the eviction list hold the
+// same number of entries. It is a cheap constant time sanity check; false
+// means the cache has been corrupted.
+func (c *LRU) Consistent() bool {
+	return len(c.items) == c.evictList.Len()
+}
+
+// PauseEviction stops Add from evicting, letting the cache grow beyond its
+// size until ResumeEviction is called.
+func (c *LRU) PauseEviction() {
+	c.paused = true
+}
+
+// ResumeEviction re-enables eviction and trims the cache back down to its
+// size from the tail, returning the number of entries evicted.
+func (c *LRU) ResumeEviction() (evicted int) {
+	c.paused = false
+	for c.Len() > c.size && c.removeOldest(nil) {
+		evicted++
+	}
+	c.checkOverflow()
+	return evicted
+}
+
+// Bump starts a new generation, making every entry added before it
+// invisible to Get, Peek, Contains and Keys. It is an O(1) alternative to
+// Purge; the hidden entries are reclaimed lazily by Get or by eviction, and
+// count towards Len until then.
+func (c *LRU) Bump() {
+	c.generation++
+}
+
+// Generation returns the current generation, starting at 0.
+func (c *LRU) Generation() uint64 {
+	return c.generation
+}
+
+// Size returns the configured capacity of the cache.
+func (c *LRU) Size() int {
+	return c.size
+}
+
+// removeOldest evicts the oldest evictable item other than skip from the
+// cache, returning false if there was none.
+func (c *LRU) removeOldest(skip *list.Element) bool {
+	ent := c.victim(skip)
+	if ent == nil {
+		return false
+	}
+	c.recordAge(time.Since(ent.Value.(*entry).added))
+	c.removeElement(ent, ReasonEvicted)
+	return true
+}
+
+// recordAge adds the residency of an evicted entry to the age stats
+func (c *LRU) recordAge(age time.Duration) {
+	a := &c.ages
+	if a.count == 0 || age < a.min {
+		a.min = age
+	}
+	if age > a.max {
+		a.max = age
+	}
+	a.sum += age
+	a.count++
+}
+
+// EvictionAgeStats reports how long entries evicted to make room had been
+// in the cache since first inserted. Explicit removals are not counted.
+// All durations are zero if nothing has been evicted.
+func (c *LRU) EvictionAgeStats() (min, max, mean time.Duration, count int) {
+	a := c.ages
+	if a.count == 0 {
+		return 0, 0, 0, 0
+	}
+	return a.min, a.max, a.sum / time.Duration(a.count), a.count
+}
+
+// checkOverflow records an overflow event if eviction has left the cache
+// above its size, or above its high-water mark when evicting in batches
+func (c *LRU) checkOverflow() {
+	if over := c.evictList.Len() - c.size - c.evictBatch; over > 0 {
+		c.overflow.events++
+		if over > c.overflow.max {
+			c.overflow.max = over
+		}
+	}
+}
+
+// OverflowStats reports the most entries the cache has held above its size
+// and how many times eviction failed to bring it back within size, because
+// every candidate was pinned, too young or vetoed. Growth while eviction is
+// paused is not counted.
+func (c *LRU) OverflowStats() (maxOverflow int, events int64) {
+	return c.overflow.max, c.overflow.events
+}
+
+// victim returns the oldest element other than skip that may be evicted,
+// taken from the lowest priority tier that has one, or nil if there is none.
+func (c *LRU) victim(skip *list.Element) *list.Element {
+	var now time.Time
+	if c.minDwell > 0 || c.timedPins {
+		now = time.Now()
+	}
+
+	// One pass from the oldest end, keeping the oldest candidate of the
+	// lowest tier seen so far and stopping at one from the lowest tier
+	var best *list.Element
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		if ent == skip {
+			continue
+		}
+		kv := ent.Value.(*entry)
+		if best != nil && kv.priority >= best.Value.(*entry).priority {
+			continue
+		}
+		if !c.evictable(kv, now) {
+			continue
+		}
+		if c.tiers == nil || kv.priority == c.tierOrder[0] {
+			return ent
+		}
+		best = ent
 	}
+	return best
+}
+
+// evictable reports whether an entry may be evicted now
+func (c *LRU) evictable(kv *entry, now time.Time) bool {
+	if c.minDwell > 0 && now.Sub(kv.added) < c.minDwell {
+		return false
+	}
+	if kv.uses > 0 || now.Before(kv.pinnedUntil) {
+		return false
+	}
+	return c.canEvict == nil || c.canEvict(kv.key, kv.value)
 }
 
 // removeElement is used to remove a given list element from the cache
-func (c *LRU) removeElement(e *list.Element) {
+func (c *LRU) removeElement(e *list.Element, reason RemoveReason) {
+	kv := c.unlink(e)
+	if c.onEvict != nil {
+		c.onEvict(kv.key, kv.value)
+	}
+	if c.onRemove != nil {
+		c.onRemove(kv.key, kv.value, reason)
+	}
+}
+
+// unlink removes a list element and its bookkeeping without running any
+// callbacks
+func (c *LRU) unlink(e *list.Element) *entry {
 	c.evictList.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.items, kv.key)
-	if c.onEvict != nil {
-		c.onEvict(kv.key, kv.value)
+	if c.dirty != nil {
+		delete(c.dirty, kv.key)
 	}
+	if c.tiers != nil {
+		c.untier(kv)
+	}
+	return kv
 }
 
-// Resize changes the cache size.
-func (c *LRU) Resize(size int) (evicted int) {
-	diff := c.Len() - size
-	if diff < 0 {
-		diff = 0
+// added is called after every insert or update of an entry
+func (c *LRU) added(kv *entry, isUpdate bool) {
+	c.versions++
+	kv.version = c.versions
+	if c.dirty != nil {
+		c.dirty[kv.key] = struct{}{}
+	}
+	if c.accesses {
+		kv.accessed = time.Now()
+	}
+	if c.onAdd != nil {
+		c.onAdd(kv.key, kv.value, isUpdate)
 	}
-	for i := 0; i < diff; i++ {
-		c.removeOldest()
+}
+
+// Shrink rebuilds the key index into a freshly sized map, releasing the
+// memory a Go map keeps after many deletions. Entries and their order are
+// unchanged. It costs time linear in the number of entries.
+func (c *LRU) Shrink() {
+	items := make(map[interface{}]*list.Element, len(c.items))
+	for k, ent := range c.items {
+		items[k] = ent
+	}
+	c.items = items
+	if c.dirty != nil {
+		dirty := make(map[interface{}]struct{}, len(c.dirty))
+		for k := range c.dirty {
+			dirty[k] = struct{}{}
+		}
+		c.dirty = dirty
+	}
+}
+
+// Resize changes the cache size, evicting down to the new size. An
+// eviction batch is kept, so the length may again grow to size+batch.
+func (c *LRU) Resize(size int) (evicted int) {
+	for c.Len() > size && c.removeOldest(nil) {
+		evicted++
 	}
 	c.size = size
-	return diff
+	c.checkOverflow()
+	return evicted
 }
@@ -1,6 +1,9 @@
 package simplelru
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestLRU(t *testing.T) {
 	evictCounter := 0
@@ -204,3 +207,1005 @@ func TestLRU_Resize(t *testing.T) {
 		t.Errorf("Cache should have contained 2 elements")
 	}
 }
+
+// Test that Add evicts a whole batch once the size is exceeded
+func TestLRU_EvictBatch(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewLRUWithEvictBatch(4, 2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := NewLRUWithEvictBatch(4, -1, nil); err == nil {
+		t.Fatalf("should reject a negative batch")
+	}
+
+	// The length may reach size+batch before anything is evicted
+	for i := 0; i < 6; i++ {
+		if l.Add(i, i) {
+			t.Fatalf("should not have evicted")
+		}
+	}
+	if !l.Add(6, 6) {
+		t.Fatalf("should have evicted")
+	}
+	if l.Len() != 4 || evictCounter != 3 {
+		t.Fatalf("bad len: %v evicted: %v", l.Len(), evictCounter)
+	}
+	for _, k := range []int{3, 4, 5, 6} {
+		if !l.Contains(k) {
+			t.Fatalf("%d should be contained", k)
+		}
+	}
+
+	l.Add(7, 7)
+	l.Add(8, 8)
+	if l.Len() != 6 || evictCounter != 3 {
+		t.Fatalf("bad len: %v evicted: %v", l.Len(), evictCounter)
+	}
+	if max, events := l.OverflowStats(); max != 0 || events != 0 {
+		t.Errorf("batch growth is not an overflow: %d %d", max, events)
+	}
+
+	// Resize evicts down to the new size and keeps the batch, even one
+	// larger than the new size
+	if n := l.Resize(1); n != 5 || l.Len() != 1 {
+		t.Fatalf("bad resize: %d evicted, len %d", n, l.Len())
+	}
+	for i := 9; i < 11; i++ {
+		if l.Add(i, i) {
+			t.Fatalf("should not have evicted")
+		}
+	}
+	if !l.Add(11, 11) || l.Len() != 1 || !l.Contains(11) {
+		t.Fatalf("bad len after resize: %v", l.Len())
+	}
+}
+
+// Test that Demote makes a key the next to be evicted
+func TestLRU_Demote(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.Demote(2) {
+		t.Errorf("2 should be contained")
+	}
+	if l.Demote(3) {
+		t.Errorf("3 should not be contained")
+	}
+	if k, _, _ := l.GetOldest(); k != 2 {
+		t.Errorf("2 should be the oldest: %v", k)
+	}
+
+	l.Add(3, 3)
+	if l.Contains(2) {
+		t.Errorf("Demote should have made 2 the eviction victim")
+	}
+	if v, ok := l.Peek(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+}
+
+// Test that AddCold inserts at the back of the eviction list
+func TestLRU_AddCold(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if l.AddCold(2, 2) {
+		t.Errorf("should not have an eviction")
+	}
+	if k, _, _ := l.GetOldest(); k != 2 {
+		t.Errorf("2 should be the oldest: %v", k)
+	}
+
+	// The cold entry is evicted first, but never the one being added
+	if !l.AddCold(3, 3) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Contains(2) || !l.Contains(1) || !l.Contains(3) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+
+	// Updating an existing key keeps its position
+	l.AddCold(1, 10)
+	if v, _ := l.Peek(1); v != 10 {
+		t.Errorf("1 should be set to 10: %v", v)
+	}
+	if k, _, _ := l.GetOldest(); k != 3 {
+		t.Errorf("3 should be the oldest: %v", k)
+	}
+}
+
+// Test that canEvict can veto evictions
+func TestLRU_CanEvict(t *testing.T) {
+	canEvict := func(k interface{}, v interface{}) bool {
+		return v.(int) >= 0
+	}
+	l, err := NewLRUWithCanEvict(2, nil, canEvict)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The vetoed oldest entry is skipped in favour of the next one
+	l.Add(1, -1)
+	l.Add(2, 2)
+	if !l.Add(3, 3) {
+		t.Errorf("should have an eviction")
+	}
+	if !l.Contains(1) || l.Contains(2) || !l.Contains(3) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+
+	// With nothing evictable the cache overflows
+	l.Add(3, -3)
+	if l.Add(4, 4) {
+		t.Errorf("should not have an eviction")
+	}
+	if l.Len() != 3 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	// Resize only evicts what it may
+	if evicted := l.Resize(1); evicted != 1 {
+		t.Errorf("1 element should have been evicted: %v", evicted)
+	}
+	if l.Contains(4) {
+		t.Errorf("4 should have been evicted")
+	}
+}
+
+// Test that PeekN returns the newest entries without reordering
+func TestLRU_PeekN(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if kvs := l.PeekN(2); len(kvs) != 0 {
+		t.Errorf("should be empty: %v", kvs)
+	}
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+
+	kvs := l.PeekN(2)
+	if len(kvs) != 2 || kvs[0] != (KV{3, 3}) || kvs[1] != (KV{2, 2}) {
+		t.Errorf("bad entries: %v", kvs)
+	}
+	if kvs := l.PeekN(10); len(kvs) != 4 {
+		t.Errorf("bad entries: %v", kvs)
+	}
+	if k, _, _ := l.GetOldest(); k != 0 {
+		t.Errorf("PeekN should not have updated recent-ness: %v", k)
+	}
+}
+
+// Test that KeysNewestFirst is the reverse of Keys
+func TestLRU_KeysNewestFirst(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Get(1)
+
+	keys := l.Keys()
+	newest := l.KeysNewestFirst()
+	if len(newest) != len(keys) {
+		t.Fatalf("bad len: %v", len(newest))
+	}
+	for i, k := range newest {
+		if k != keys[len(keys)-1-i] {
+			t.Errorf("out of order key: %v", newest)
+		}
+	}
+	if newest[0] != 1 {
+		t.Errorf("1 should be the newest key: %v", newest)
+	}
+}
+
+// Test that entries added with a deadline expire
+func TestLRU_AddExpireAt(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddExpireAt(1, 1, time.Now().Add(time.Hour))
+	l.AddExpireAt(2, 2, time.Now().Add(-time.Second))
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if l.Contains(2) {
+		t.Errorf("2 should be expired")
+	}
+	if _, ok := l.Peek(2); ok {
+		t.Errorf("2 should be expired")
+	}
+	if _, ok := l.Get(2); ok {
+		t.Errorf("2 should be expired")
+	}
+	if l.Len() != 1 {
+		t.Errorf("Get should have removed the expired entry: %v", l.Len())
+	}
+
+	// A plain Add clears the deadline
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Second))
+	l.Add(3, 3)
+	if !l.Contains(3) {
+		t.Errorf("3 should no longer expire")
+	}
+}
+
+// Test that Size tracks Resize
+func TestLRU_Size(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.Size() != 2 {
+		t.Errorf("bad size: %v", l.Size())
+	}
+	l.Resize(5)
+	if l.Size() != 5 {
+		t.Errorf("bad size: %v", l.Size())
+	}
+}
+
+// Test that paused eviction lets the cache grow until resumed
+func TestLRU_PauseEviction(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.PauseEviction()
+	for i := 0; i < 4; i++ {
+		if l.Add(i, i) {
+			t.Errorf("should not have an eviction")
+		}
+	}
+	if l.Len() != 4 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+
+	if evicted := l.ResumeEviction(); evicted != 2 {
+		t.Errorf("2 elements should have been evicted: %v", evicted)
+	}
+	if !l.Contains(2) || !l.Contains(3) {
+		t.Errorf("the newest entries should be kept: %v", l.Keys())
+	}
+	if !l.Add(4, 4) {
+		t.Errorf("should have an eviction")
+	}
+}
+
+// Test that GetOldestEvictable skips vetoed entries
+func TestLRU_GetOldestEvictable(t *testing.T) {
+	canEvict := func(k interface{}, v interface{}) bool {
+		return k != 1
+	}
+	l, err := NewLRUWithCanEvict(3, nil, canEvict)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, _, ok := l.GetOldestEvictable(); ok {
+		t.Errorf("empty cache should have no victim")
+	}
+	l.Add(1, 1)
+	if _, _, ok := l.GetOldestEvictable(); ok {
+		t.Errorf("1 may not be evicted")
+	}
+	l.Add(2, 2)
+	l.Add(3, 3)
+	if k, v, ok := l.GetOldestEvictable(); !ok || k != 2 || v != 2 {
+		t.Errorf("2 should be the victim: %v, %v, %v", k, v, ok)
+	}
+	if k, _, _ := l.GetOldest(); k != 1 {
+		t.Errorf("1 should still be the oldest: %v", k)
+	}
+}
+
+// Test that Rank counts from the most recent entry
+func TestLRU_Rank(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	for k, want := range map[int]int{3: 0, 2: 1, 0: 3} {
+		if rank, ok := l.Rank(k); !ok || rank != want {
+			t.Errorf("bad rank for %d: %v, %v", k, rank, ok)
+		}
+	}
+	if _, ok := l.Rank(5); ok {
+		t.Errorf("5 should not be contained")
+	}
+	if k, _, _ := l.GetOldest(); k != 0 {
+		t.Errorf("Rank should not have updated recent-ness: %v", k)
+	}
+}
+
+// Test that Get only promotes after k hits
+func TestLRU_PromoteThreshold(t *testing.T) {
+	l, err := NewLRUWithPromoteThreshold(2, 2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+	if k, _, _ := l.GetOldest(); k != 1 {
+		t.Errorf("one hit should not have promoted 1")
+	}
+	l.Get(1)
+	if k, _, _ := l.GetOldest(); k != 2 {
+		t.Errorf("two hits should have promoted 1")
+	}
+
+	// The count restarts after a promotion
+	l.Get(1)
+	l.Get(2)
+	l.Get(2)
+	if k, _, _ := l.GetOldest(); k != 1 {
+		t.Errorf("2 should have been promoted past 1")
+	}
+}
+
+// Test that entries younger than the minimum residency are not evicted
+func TestLRU_MinResidency(t *testing.T) {
+	l, err := NewLRUWithMinResidency(2, 20*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	time.Sleep(30 * time.Millisecond)
+	l.Add(2, 2)
+	l.Demote(2)
+
+	// 2 is the tail but too young, so the aged 1 is evicted
+	l.Add(3, 3)
+	if l.Contains(1) || !l.Contains(2) {
+		t.Errorf("bad keys: %v", l.Keys())
+	}
+
+	// With every entry too young the cache overflows
+	if l.Add(4, 4) {
+		t.Errorf("should not have an eviction")
+	}
+	if l.Len() != 3 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// Test that nil keys can be rejected
+func TestLRU_RejectNilKeys(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(nil, 1)
+	if !l.Contains(nil) {
+		t.Errorf("nil keys are allowed by default")
+	}
+	l.Purge()
+
+	l.RejectNilKeys()
+	l.Add(nil, 1)
+	l.AddCold(nil, 1)
+	l.AddExpireAt(nil, 1, time.Now().Add(time.Hour))
+	if l.Len() != 0 {
+		t.Errorf("nil keys should have been ignored: %v", l.Len())
+	}
+}
+
+// Test that Bump hides entries from earlier generations
+func TestLRU_Bump(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Bump()
+	if l.Generation() != 1 {
+		t.Errorf("bad generation: %v", l.Generation())
+	}
+	if l.Contains(1) {
+		t.Errorf("1 should be hidden")
+	}
+	if _, ok := l.Peek(2); ok {
+		t.Errorf("2 should be hidden")
+	}
+	if len(l.Keys()) != 0 || len(l.KeysNewestFirst()) != 0 {
+		t.Errorf("hidden keys should not be listed: %v", l.Keys())
+	}
+	if _, ok := l.Get(1); ok {
+		t.Errorf("1 should be hidden")
+	}
+	if l.Len() != 1 {
+		t.Errorf("Get should have reclaimed 1: %v", l.Len())
+	}
+
+	// Re-adding brings an entry into the current generation
+	l.Add(2, 20)
+	if v, ok := l.Get(2); !ok || v != 20 {
+		t.Errorf("2 should be set to 20: %v, %v", v, ok)
+	}
+}
+
+// Test that onAdd tells inserts from updates
+func TestLRU_OnAdd(t *testing.T) {
+	var adds []KV
+	var updates []bool
+	onAdd := func(k interface{}, v interface{}, isUpdate bool) {
+		adds = append(adds, KV{k, v})
+		updates = append(updates, isUpdate)
+	}
+	l, err := NewLRUWithOnAdd(2, nil, onAdd)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(1, 10)
+	l.AddCold(2, 2)
+	l.AddCold(2, 20)
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Second))
+	l.Add(3, 30)
+
+	wantUpdates := []bool{false, true, false, true, false, false}
+	if len(updates) != len(wantUpdates) {
+		t.Fatalf("bad adds: %v", adds)
+	}
+	for i := range wantUpdates {
+		if updates[i] != wantUpdates[i] {
+			t.Errorf("bad isUpdate for %v: %v", adds[i], updates[i])
+		}
+	}
+	if adds[1] != (KV{1, 10}) {
+		t.Errorf("bad add: %v", adds[1])
+	}
+}
+
+// Test that dirty keys are tracked until cleared or removed
+func TestLRU_Dirty(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if keys := l.DirtyKeys(); len(keys) != 0 {
+		t.Errorf("dirty keys are not tracked by default: %v", keys)
+	}
+
+	l.TrackDirty()
+	l.Add(2, 2)
+	l.AddCold(3, 3)
+	if keys := l.DirtyKeys(); len(keys) != 2 || keys[0] == 1 || keys[1] == 1 {
+		t.Errorf("1 was evicted and predates tracking: %v", keys)
+	}
+	l.ClearDirty()
+	if keys := l.DirtyKeys(); len(keys) != 0 {
+		t.Errorf("bad dirty keys: %v", keys)
+	}
+	l.Add(2, 20)
+	l.Remove(3)
+	if keys := l.DirtyKeys(); len(keys) != 1 || keys[0] != 2 {
+		t.Errorf("bad dirty keys: %v", keys)
+	}
+}
+
+// Test that RemoveAddedBefore drops entries by insert time
+func TestLRU_RemoveAddedBefore(t *testing.T) {
+	evicted := 0
+	l, err := NewLRU(8, func(k, v interface{}) { evicted++ })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.AddExpireAt(2, 2, time.Now().Add(time.Hour))
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	l.Add(3, 3)
+	l.Add(1, 10)
+
+	if n := l.RemoveAddedBefore(cutoff); n != 2 {
+		t.Errorf("bad removed count: %d", n)
+	}
+	if evicted != 2 {
+		t.Errorf("bad evict count: %d", evicted)
+	}
+	if l.Len() != 1 || !l.Contains(3) {
+		t.Errorf("only 3 should remain: %v", l.Keys())
+	}
+}
+
+// Test that Take removes an entry without callbacks and keeps its deadline
+func TestLRU_Take(t *testing.T) {
+	evicted := 0
+	l, err := NewLRU(4, func(k, v interface{}) { evicted++ })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	l.Add(1, 1)
+	l.AddExpireAt(2, 2, deadline)
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Second))
+
+	if v, exp, ok := l.Take(1); !ok || v != 1 || !exp.IsZero() {
+		t.Errorf("bad take: %v %v %v", v, exp, ok)
+	}
+	if v, exp, ok := l.Take(2); !ok || v != 2 || !exp.Equal(deadline) {
+		t.Errorf("bad take: %v %v %v", v, exp, ok)
+	}
+	if _, _, ok := l.Take(3); ok {
+		t.Errorf("expired entry should not be taken")
+	}
+	if _, _, ok := l.Take(4); ok {
+		t.Errorf("missing entry should not be taken")
+	}
+	if evicted != 0 || l.Len() != 1 || l.Contains(1) {
+		t.Errorf("bad state: %d evicted, %v", evicted, l.Keys())
+	}
+}
+
+// Test that RemoveAccessedBefore drops entries by last access time
+func TestLRU_RemoveAccessedBefore(t *testing.T) {
+	l, err := NewLRU(8, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	if n := l.RemoveAccessedBefore(time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("nothing should be removed without tracking: %d", n)
+	}
+
+	l.TrackAccess()
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Add(4, 4)
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	l.Get(2)
+	l.Add(3, 30)
+	l.Peek(4)
+
+	if n := l.RemoveAccessedBefore(cutoff); n != 2 {
+		t.Errorf("bad removed count: %d", n)
+	}
+	if l.Len() != 2 || !l.Contains(2) || !l.Contains(3) {
+		t.Errorf("only 2 and 3 should remain: %v", l.Keys())
+	}
+}
+
+// Test that only capacity evictions feed the eviction age stats
+func TestLRU_EvictionAgeStats(t *testing.T) {
+	l, err := NewLRU(1, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.RemoveAddedBefore(time.Now().Add(time.Hour))
+	l.Add(3, 3)
+	l.Resize(0)
+
+	min, max, mean, n := l.EvictionAgeStats()
+	if n != 2 {
+		t.Errorf("bad count: %d", n)
+	}
+	if min > mean || mean > max {
+		t.Errorf("bad ages: min %v max %v mean %v", min, max, mean)
+	}
+}
+
+// Test that acquired entries are not evicted until released
+func TestLRU_Acquire(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	_, release, ok := l.Acquire(1)
+	if !ok {
+		t.Fatalf("1 should be contained")
+	}
+	_, release2, _ := l.Acquire(1)
+	if _, _, ok := l.Acquire(3); ok {
+		t.Errorf("3 should not be contained")
+	}
+
+	l.Add(3, 3)
+	l.Add(4, 4)
+	if !l.Contains(1) || l.Len() != 2 {
+		t.Errorf("1 should be pinned: %v", l.Keys())
+	}
+
+	release()
+	release()
+	l.Demote(1)
+	l.Add(5, 5)
+	if !l.Contains(1) || l.Len() != 2 {
+		t.Errorf("1 is still held once: %v", l.Keys())
+	}
+
+	release2()
+	l.Add(6, 6)
+	if l.Contains(1) {
+		t.Errorf("1 should be evicted once released: %v", l.Keys())
+	}
+}
+
+// Test that overflow is counted only when eviction cannot make room
+func TestLRU_OverflowStats(t *testing.T) {
+	l, err := NewLRUWithCanEvict(1, nil, func(k, v interface{}) bool { return k != 1 })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.PauseEviction()
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if max, n := l.OverflowStats(); max != 0 || n != 0 {
+		t.Errorf("paused growth should not count: %d %d", max, n)
+	}
+
+	l.ResumeEviction()
+	l.AddCold(3, 3)
+	if max, n := l.OverflowStats(); max != 1 || n != 1 {
+		t.Errorf("bad overflow stats: %d %d", max, n)
+	}
+	l.Add(4, 4)
+	if max, n := l.OverflowStats(); max != 1 || n != 2 {
+		t.Errorf("bad overflow stats: %d %d", max, n)
+	}
+}
+
+// Test that eviction drains lower priority tiers first
+func TestLRU_AddWithPriority(t *testing.T) {
+	l, err := NewLRU(3, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddWithPriority("user", 1, 1)
+	l.Add("anon1", 2)
+	l.Add("anon2", 3)
+	l.Get("user")
+	l.Get("anon1")
+
+	l.Add("anon3", 4)
+	if l.Contains("anon2") || !l.Contains("user") {
+		t.Errorf("anon2 should be evicted first: %v", l.Keys())
+	}
+	l.Add("anon4", 5)
+	l.Add("anon5", 6)
+	if !l.Contains("user") {
+		t.Errorf("user should outlive tier 0: %v", l.Keys())
+	}
+	if k, _, _ := l.GetOldestEvictable(); k != "anon4" {
+		t.Errorf("bad next victim: %v", k)
+	}
+
+	l.Add("user", 10)
+	l.AddWithPriority("anon5", 6, -1)
+	l.Add("anon6", 7)
+	if l.Contains("anon5") || !l.Contains("user") {
+		t.Errorf("negative tier should go first and re-adding should keep the tier: %v", l.Keys())
+	}
+
+	l.Remove("user")
+	l.Add("anon7", 8)
+	l.Add("anon8", 9)
+	if l.Len() != 3 || l.Contains("anon4") {
+		t.Errorf("bad keys after tier emptied: %v", l.Keys())
+	}
+}
+
+// Test that a new key in a tier below every entry is evicted itself
+func TestLRU_AddWithPriorityLowest(t *testing.T) {
+	var evicted []interface{}
+	l, err := NewLRU(2, func(k, v interface{}) {
+		evicted = append(evicted, k)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddWithPriority("a", 1, 5)
+	l.AddWithPriority("b", 2, 5)
+	if !l.AddWithPriority("low", 3, 0) {
+		t.Errorf("should report the eviction")
+	}
+	if !l.Contains("a") || !l.Contains("b") || l.Contains("low") {
+		t.Errorf("the lowest tier newcomer should go: %v", l.Keys())
+	}
+	if len(evicted) != 1 || evicted[0] != "low" {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+
+	// Sharing the lowest tier, the newcomer outlives older entries
+	l.AddWithPriority("c", 4, 5)
+	if l.Contains("a") || !l.Contains("c") {
+		t.Errorf("the oldest of the tier should go: %v", l.Keys())
+	}
+
+	// Plain Add never evicts the entry it adds
+	l.Add("plain", 5)
+	if !l.Contains("plain") || l.Contains("b") {
+		t.Errorf("bad keys after Add: %v", l.Keys())
+	}
+}
+
+// Test that Shrink keeps entries and their order
+func TestLRU_Shrink(t *testing.T) {
+	l, err := NewLRU(1000, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.TrackDirty()
+	for i := 0; i < 1000; i++ {
+		l.Add(i, i)
+	}
+	for i := 0; i < 1000; i++ {
+		if i%100 != 0 {
+			l.Remove(i)
+		}
+	}
+	l.Get(0)
+	l.Shrink()
+
+	keys := l.Keys()
+	want := []interface{}{100, 200, 300, 400, 500, 600, 700, 800, 900, 0}
+	if len(keys) != len(want) {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("bad keys: %v", keys)
+		}
+	}
+	if v, ok := l.Get(500); !ok || v != 500 {
+		t.Errorf("bad value: %v", v)
+	}
+	if n := len(l.DirtyKeys()); n != 10 {
+		t.Errorf("bad dirty count: %d", n)
+	}
+	l.Add(1, 1)
+	if !l.Contains(1) || l.Len() != 11 {
+		t.Errorf("cache should still accept adds")
+	}
+}
+
+// Test that Update replaces or removes in place
+func TestLRU_Update(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.Update(1, func(old interface{}) (interface{}, bool) { return old.(int) + 10, true }) {
+		t.Fatalf("1 should be contained")
+	}
+	if v, _ := l.Peek(1); v != 11 {
+		t.Errorf("bad value: %v", v)
+	}
+	l.Add(3, 3)
+	if l.Contains(1) {
+		t.Errorf("Update should not change recent-ness")
+	}
+
+	if !l.Update(2, func(interface{}) (interface{}, bool) { return nil, false }) || l.Contains(2) {
+		t.Errorf("2 should be removed")
+	}
+	called := false
+	if l.Update(4, func(interface{}) (interface{}, bool) { called = true; return nil, true }) || called {
+		t.Errorf("missing key should not be updated")
+	}
+}
+
+// Test that the remove callback reports why entries left
+func TestLRU_RemoveCallback(t *testing.T) {
+	var reasons []RemoveReason
+	evicted := 0
+	l, err := NewLRUWithRemoveCallback(1, func(k, v interface{}) { evicted++ }, func(k, v interface{}, r RemoveReason) {
+		reasons = append(reasons, r)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Remove(2)
+	l.AddExpireAt(3, 3, time.Now().Add(-time.Second))
+	l.Get(3)
+	l.Add(4, 4)
+	l.Purge()
+
+	want := []RemoveReason{ReasonEvicted, ReasonRemoved, ReasonExpired, ReasonPurged}
+	if len(reasons) != len(want) || evicted != len(want) {
+		t.Fatalf("bad reasons: %v", reasons)
+	}
+	for i := range want {
+		if reasons[i] != want[i] {
+			t.Errorf("bad reason %d: %v", i, reasons[i])
+		}
+	}
+}
+
+// Test that Get does not promote entries close to expiry
+func TestLRU_FreshnessPromotion(t *testing.T) {
+	l, err := NewLRUWithFreshnessPromotion(2, time.Minute, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.AddExpireAt(1, 1, time.Now().Add(30*time.Second))
+	l.AddExpireAt(2, 2, time.Now().Add(time.Hour))
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Fatalf("near-expiry entry should still be returned")
+	}
+	l.Add(3, 3)
+	if l.Contains(1) {
+		t.Errorf("1 should not have been promoted: %v", l.Keys())
+	}
+
+	l.Get(2)
+	l.Add(4, 4)
+	if !l.Contains(2) || l.Contains(3) {
+		t.Errorf("fresh and undated entries should promote: %v", l.Keys())
+	}
+}
+
+// Test that every write gives a value a new version
+func TestLRU_Version(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, ok := l.Version(1); ok {
+		t.Errorf("1 should be missing")
+	}
+	l.Add(1, 1)
+	v1, ok := l.Version(1)
+	if !ok {
+		t.Fatalf("1 should be contained")
+	}
+	l.Get(1)
+	if v, _ := l.Version(1); v != v1 {
+		t.Errorf("reads should not change the version")
+	}
+
+	l.Update(1, func(old interface{}) (interface{}, bool) { return 2, true })
+	v2, _ := l.Version(1)
+	l.Remove(1)
+	l.AddCold(1, 3)
+	v3, _ := l.Version(1)
+	if !(v1 < v2 && v2 < v3) {
+		t.Errorf("versions should increase: %d %d %d", v1, v2, v3)
+	}
+}
+
+// Test that PurgeOrdered calls back from oldest to newest
+func TestLRU_PurgeOrdered(t *testing.T) {
+	var order []interface{}
+	l, err := NewLRU(8, func(k, v interface{}) { order = append(order, k) })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+	}
+	l.Get(0)
+	l.PurgeOrdered()
+
+	want := []interface{}{1, 2, 3, 4, 5, 6, 7, 0}
+	if len(order) != len(want) {
+		t.Fatalf("bad order: %v", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("bad order: %v", order)
+		}
+	}
+	if l.Len() != 0 || l.Contains(0) {
+		t.Errorf("cache should be empty")
+	}
+	l.Add(9, 9)
+	if l.Len() != 1 {
+		t.Errorf("bad len: %d", l.Len())
+	}
+}
+
+// Test that timed pins protect entries until they lapse
+func TestLRU_PinFor(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.PinFor(1, time.Hour) {
+		t.Errorf("1 should be missing")
+	}
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.PinFor(1, time.Hour)
+	l.PinFor(2, 20*time.Millisecond)
+	l.Add(3, 3)
+	if !l.Contains(1) || !l.Contains(2) || l.Len() != 3 {
+		t.Errorf("pinned entries should overflow: %v", l.Keys())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	l.Add(4, 4)
+	if l.Contains(2) || !l.Contains(1) {
+		t.Errorf("lapsed pin should be evictable: %v", l.Keys())
+	}
+	l.PinFor(1, 0)
+	l.Add(5, 5)
+	if l.Contains(1) {
+		t.Errorf("re-pinning should replace the deadline: %v", l.Keys())
+	}
+}
+
+// Test that Consistent notices the index and list disagreeing
+func TestLRU_Consistent(t *testing.T) {
+	l, err := NewLRU(2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Remove(3)
+	l.Purge()
+	l.Add(1, 1)
+	if !l.Consistent() {
+		t.Fatalf("should be consistent")
+	}
+
+	delete(l.items, 1)
+	if l.Consistent() {
+		t.Errorf("should notice the missing index entry")
+	}
+}
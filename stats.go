@@ -0,0 +1,241 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Stats holds counters describing how effective a Cache has been.
+type Stats struct {
+	Hits   int64 // Hits is the number of lookups that found a value
+	Misses int64 // Misses is the number of lookups that found nothing
+
+	// MaxOverflow is the most entries the cache has held beyond its size
+	// because pinned, young or vetoed entries could not be evicted.
+	MaxOverflow int
+	// OverflowEvents is the number of times eviction left the cache above
+	// its size.
+	OverflowEvents int64
+}
+
+// HitRate returns the fraction of lookups that were hits, or 0 if there
+// were no lookups.
+func (s Stats) HitRate() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// Sub returns the change in counters from prev to s. MaxOverflow is a
+// peak rather than a counter, so it is carried over from s unchanged.
+func (s Stats) Sub(prev Stats) Stats {
+	return Stats{
+		Hits:           s.Hits - prev.Hits,
+		Misses:         s.Misses - prev.Misses,
+		MaxOverflow:    s.MaxOverflow,
+		OverflowEvents: s.OverflowEvents - prev.OverflowEvents,
+	}
+}
+
+// Stats returns the cumulative counters of the cache. Get, GetSlice (once
+// per key), GetForUse, GetVersioned and GetOrLoad count as lookups, and the
+// same lookups feed RecentHitRate and the "get" events of NewWithTrace;
+// Peek, Contains and the other read-only walks do not.
+func (c *Cache) Stats() Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.snapshot()
+}
+
+// EvictionAgeStats reports the shortest, longest and mean time that entries
+// evicted to make room had spent in the cache, and how many there were. Many
+// young evictions suggest the cache is too small; only old ones suggest it
+// could shrink.
+func (c *Cache) EvictionAgeStats() (min, max, mean time.Duration, count int) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.EvictionAgeStats()
+}
+
+// NewWithRecentHitRate constructs a fixed size cache that remembers whether
+// each of its last window lookups hit, for RecentHitRate.
+func NewWithRecentHitRate(size, window int) (*Cache, error) {
+	if window <= 0 {
+		return nil, errors.New("Must provide a positive window")
+	}
+	c, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.lookups = &lookupRing{hits: make([]bool, window)}
+	return c, nil
+}
+
+// RecentHitRate returns the fraction of the last n lookups that were hits,
+// counting lookups as Stats does. Unlike a time window it is independent of
+// how bursty the traffic is. n is capped at the window the cache was
+// constructed with and at the number of lookups so far; the rate is 0 if
+// there were none or the cache was not built with NewWithRecentHitRate.
+func (c *Cache) RecentHitRate(n int) float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.lookups == nil {
+		return 0
+	}
+	return c.lookups.rate(n)
+}
+
+// lookupRing records whether the most recent lookups hit
+type lookupRing struct {
+	hits  []bool
+	next  int
+	count int
+}
+
+// add records a lookup, overwriting the oldest once the ring is full
+func (r *lookupRing) add(hit bool) {
+	r.hits[r.next] = hit
+	r.next = (r.next + 1) % len(r.hits)
+	if r.count < len(r.hits) {
+		r.count++
+	}
+}
+
+// rate returns the hit fraction of the last n recorded lookups
+func (r *lookupRing) rate(n int) float64 {
+	if n > r.count {
+		n = r.count
+	}
+	if n <= 0 {
+		return 0
+	}
+	hits := 0
+	for i := 1; i <= n; i++ {
+		if r.hits[(r.next-i+len(r.hits))%len(r.hits)] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+// Report returns the number of items in the cache together with its
+// stats, taken under one lock so that both describe the same instant.
+func (c *Cache) Report() (len int, stats Stats) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lru.Len(), c.snapshot()
+}
+
+// snapshot returns the stats with the overflow counters of the underlying
+// LRU filled in. The caller must hold the lock.
+func (c *Cache) snapshot() Stats {
+	s := c.stats
+	s.MaxOverflow, s.OverflowEvents = c.lru.OverflowStats()
+	return s
+}
+
+// StartMetricsTicker calls fn every interval with the stats accumulated
+// since the previous tick, giving windowed rather than cumulative hit
+// rates. interval must be positive; like time.NewTicker, it panics
+// otherwise, before starting anything. The returned stop function ends the
+// ticker and may be called more than once.
+func (c *Cache) StartMetricsTicker(interval time.Duration, fn func(window Stats)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	last := c.Stats()
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cur := c.Stats()
+				fn(cur.Sub(last))
+				last = cur
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// autoResizeMargin is how far above the target the windowed hit rate must
+// be before StartAutoResize shrinks the cache, so that it does not
+// oscillate around the target.
+const autoResizeMargin = 0.05
+
+// StartAutoResize checks the hit rate every interval and resizes the cache
+// by step entries to track targetHitRate: it grows, up to maxSize, while
+// the rate is below target, and shrinks, down to minSize, once the rate is
+// more than 0.05 above target. Intervals without lookups leave the size
+// alone. minSize, step and interval must be positive and minSize no more
+// than maxSize, or an error is returned and nothing is started. The
+// returned stop function ends the controller and may be called more than
+// once.
+func (c *Cache) StartAutoResize(targetHitRate float64, minSize, maxSize, step int, interval time.Duration) (stop func(), err error) {
+	switch {
+	case minSize <= 0:
+		return nil, errors.New("Must provide a positive min size")
+	case maxSize < minSize:
+		return nil, errors.New("Must provide a max size of at least min size")
+	case step <= 0:
+		return nil, errors.New("Must provide a positive step")
+	case interval <= 0:
+		return nil, errors.New("Must provide a positive interval")
+	}
+	return c.StartMetricsTicker(interval, func(window Stats) {
+		if window.Hits+window.Misses == 0 {
+			return
+		}
+		rate := window.HitRate()
+
+		c.lock.Lock()
+		defer c.unlock()
+		size := c.lru.Size()
+		switch {
+		case rate < targetHitRate && size < maxSize:
+			size += step
+			if size > maxSize {
+				size = maxSize
+			}
+		case rate > targetHitRate+autoResizeMargin && size > minSize:
+			size -= step
+			if size < minSize {
+				size = minSize
+			}
+		default:
+			return
+		}
+		c.lru.Resize(size)
+	}), nil
+}
+
+// get looks up a key in the underlying LRU, counting the hit or miss. The
+// write lock must be held.
+func (c *Cache) get(key interface{}) (interface{}, bool) {
+	value, ok := c.lru.Get(key)
+	c.countLookup(key, ok)
+	return value, ok
+}
+
+// countLookup records a lookup in the stats. The caller must hold the
+// write lock.
+func (c *Cache) countLookup(key interface{}, hit bool) {
+	if c.trace != nil {
+		c.trace("get", key)
+	}
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	if c.lookups != nil {
+		c.lookups.add(hit)
+	}
+}
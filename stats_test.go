@@ -0,0 +1,240 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Get(1)
+	l.Get(1)
+	l.Get(2)
+	l.Peek(2)
+	l.GetOrLoad(3, func() (interface{}, error) { return 3, nil })
+
+	s := l.Stats()
+	if s.Hits != 2 || s.Misses != 2 {
+		t.Errorf("bad stats: %+v", s)
+	}
+	if r := s.HitRate(); r != 0.5 {
+		t.Errorf("bad hit rate: %v", r)
+	}
+	if r := (Stats{}).HitRate(); r != 0 {
+		t.Errorf("bad hit rate: %v", r)
+	}
+}
+
+func TestStats_MetricsTicker(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	windows := make(chan Stats, 16)
+	stop := l.StartMetricsTicker(5*time.Millisecond, func(window Stats) {
+		windows <- window
+	})
+	defer stop()
+
+	l.Add(1, 1)
+	l.Get(1)
+	l.Get(2)
+
+	// Counters are only ever reported once across windows
+	var total Stats
+	timeout := time.After(time.Second)
+	for total.Hits+total.Misses < 2 {
+		select {
+		case w := <-windows:
+			total.Hits += w.Hits
+			total.Misses += w.Misses
+		case <-timeout:
+			t.Fatalf("no windows reported: %+v", total)
+		}
+	}
+	if total.Hits != 1 || total.Misses != 1 {
+		t.Errorf("bad windows: %+v", total)
+	}
+	if w := <-windows; w.Hits != 0 || w.Misses != 0 {
+		t.Errorf("window should be empty without lookups: %+v", w)
+	}
+
+	stop()
+	stop()
+}
+
+func TestStats_Report(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Get(1)
+	l.Get(3)
+
+	n, s := l.Report()
+	if n != 2 || s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("bad report: %v %+v", n, s)
+	}
+}
+
+func TestStats_EvictionAgeStats(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, _, _, n := l.EvictionAgeStats(); n != 0 {
+		t.Errorf("nothing evicted yet: %d", n)
+	}
+
+	l.Add(1, 1)
+	time.Sleep(5 * time.Millisecond)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Add(4, 4)
+	l.Remove(3)
+
+	min, max, mean, n := l.EvictionAgeStats()
+	if n != 2 {
+		t.Fatalf("removals should not count: %d", n)
+	}
+	if max < 5*time.Millisecond || min > max || mean < min || mean > max {
+		t.Errorf("bad ages: min %v max %v mean %v", min, max, mean)
+	}
+}
+
+func TestStats_Overflow(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	_, release1, _ := l.GetForUse(1)
+	_, release2, _ := l.GetForUse(2)
+	l.Add(3, 3)
+	l.Add(4, 4)
+	if s := l.Stats(); s.MaxOverflow != 1 || s.OverflowEvents != 2 {
+		t.Errorf("bad overflow stats: %+v", s)
+	}
+
+	release1()
+	release2()
+	l.Add(5, 5)
+	s := l.Stats()
+	if s.MaxOverflow != 1 || s.OverflowEvents != 2 || l.Len() != 2 {
+		t.Errorf("cache should shrink back without a new event: %+v %d", s, l.Len())
+	}
+	if d := s.Sub(Stats{OverflowEvents: 1}); d.MaxOverflow != 1 || d.OverflowEvents != 1 {
+		t.Errorf("bad window: %+v", d)
+	}
+}
+
+func TestStats_RecentHitRate(t *testing.T) {
+	l, err := NewWithRecentHitRate(4, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if r := l.RecentHitRate(10); r != 0 {
+		t.Errorf("no lookups yet: %v", r)
+	}
+	l.Add(1, 1)
+	for i := 0; i < 20; i++ {
+		l.Get(2)
+	}
+	for i := 0; i < 5; i++ {
+		l.Get(1)
+	}
+	l.Peek(2)
+
+	if r := l.RecentHitRate(5); r != 1 {
+		t.Errorf("bad rate over 5: %v", r)
+	}
+	if r := l.RecentHitRate(10); r != 0.5 {
+		t.Errorf("bad rate over 10: %v", r)
+	}
+	if r := l.RecentHitRate(1000); r != 0.5 {
+		t.Errorf("n should be capped at the window: %v", r)
+	}
+	if r := l.Stats().HitRate(); r != 0.2 {
+		t.Errorf("cumulative rate should differ: %v", r)
+	}
+
+	if _, err := NewWithRecentHitRate(4, 0); err == nil {
+		t.Errorf("should reject an empty window")
+	}
+}
+
+func TestStats_MetricsTickerInterval(t *testing.T) {
+	l, err := New(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("a zero interval should panic")
+		}
+	}()
+	l.StartMetricsTicker(0, func(Stats) {})
+}
+
+func TestStats_AutoResize(t *testing.T) {
+	l, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	size := func() int {
+		l.lock.RLock()
+		defer l.lock.RUnlock()
+		return l.lru.Size()
+	}
+
+	for _, bad := range [][3]int{{0, 10, 4}, {4, 2, 1}, {2, 10, 0}} {
+		if _, err := l.StartAutoResize(0.9, bad[0], bad[1], bad[2], time.Millisecond); err == nil {
+			t.Errorf("should reject min %d, max %d, step %d", bad[0], bad[1], bad[2])
+		}
+	}
+	if _, err := l.StartAutoResize(0.9, 2, 10, 4, 0); err == nil {
+		t.Errorf("should reject a zero interval")
+	}
+
+	stop, err := l.StartAutoResize(0.9, 2, 10, 4, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer stop()
+
+	// Every lookup misses, so the cache grows to its max
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; size() != 10; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache should grow: %d", size())
+		}
+		l.Get(i)
+		time.Sleep(time.Millisecond)
+	}
+
+	// Every lookup hits, so it shrinks to its min
+	l.Add("hot", 1)
+	for size() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache should shrink: %d", size())
+		}
+		l.Get("hot")
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	stop()
+}
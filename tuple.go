@@ -0,0 +1,70 @@
+package lru
+
+// Tuple2 is a thread-safe fixed size LRU cache keyed by a pair of values.
+// The pair is stored as a struct key, avoiding the cost and the delimiter
+// collisions of joining both parts into a string.
+type Tuple2[A, B comparable, V any] struct {
+	lru *Cache
+}
+
+// tuple2Key is the composite key stored in the underlying cache
+type tuple2Key[A, B comparable] struct {
+	a A
+	b B
+}
+
+// NewTuple2 creates a Tuple2 cache of the given size
+func NewTuple2[A, B comparable, V any](size int) (*Tuple2[A, B, V], error) {
+	lru, err := New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &Tuple2[A, B, V]{lru: lru}, nil
+}
+
+// Add adds a value to the cache.  Returns true if an eviction occurred.
+func (c *Tuple2[A, B, V]) Add(a A, b B, value V) bool {
+	return c.lru.Add(tuple2Key[A, B]{a, b}, value)
+}
+
+// Get looks up a key's value from the cache.
+func (c *Tuple2[A, B, V]) Get(a A, b B) (value V, ok bool) {
+	v, ok := c.lru.Get(tuple2Key[A, B]{a, b})
+	if !ok {
+		return value, false
+	}
+	value, _ = v.(V)
+	return value, true
+}
+
+// Peek returns the key value without updating the "recently used"-ness of
+// the key.
+func (c *Tuple2[A, B, V]) Peek(a A, b B) (value V, ok bool) {
+	v, ok := c.lru.Peek(tuple2Key[A, B]{a, b})
+	if !ok {
+		return value, false
+	}
+	value, _ = v.(V)
+	return value, true
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (c *Tuple2[A, B, V]) Contains(a A, b B) bool {
+	return c.lru.Contains(tuple2Key[A, B]{a, b})
+}
+
+// Remove removes the provided key from the cache.
+func (c *Tuple2[A, B, V]) Remove(a A, b B) {
+	c.lru.Remove(tuple2Key[A, B]{a, b})
+}
+
+// Len returns the number of items in the cache.
+func (c *Tuple2[A, B, V]) Len() int {
+	return c.lru.Len()
+}
+
+// Purge is used to completely clear the cache
+func (c *Tuple2[A, B, V]) Purge() {
+	c.lru.Purge()
+}
@@ -0,0 +1,55 @@
+package lru
+
+import "testing"
+
+func TestTuple2(t *testing.T) {
+	l, err := NewTuple2[int, string, int](2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, "a", 10)
+	l.Add(1, "b", 20)
+	if v, ok := l.Get(1, "a"); !ok || v != 10 {
+		t.Errorf("(1, a) should be set to 10: %v, %v", v, ok)
+	}
+	if v, ok := l.Peek(1, "b"); !ok || v != 20 {
+		t.Errorf("(1, b) should be set to 20: %v, %v", v, ok)
+	}
+	if _, ok := l.Get(2, "a"); ok {
+		t.Errorf("(2, a) should not be contained")
+	}
+
+	// (1, b) is the least recently used
+	if !l.Add(2, "a", 30) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Contains(1, "b") || !l.Contains(1, "a") {
+		t.Errorf("(1, b) should have been evicted")
+	}
+
+	l.Remove(1, "a")
+	if l.Len() != 1 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	l.Purge()
+	if l.Len() != 0 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+}
+
+// Test that a nil value of an interface type is returned as the zero V
+func TestTuple2_NilInterfaceValue(t *testing.T) {
+	l, err := NewTuple2[int, string, interface{}](2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, "a", nil)
+	if v, ok := l.Get(1, "a"); !ok || v != nil {
+		t.Errorf("bad value: %v, %v", v, ok)
+	}
+	if v, ok := l.Peek(1, "a"); !ok || v != nil {
+		t.Errorf("bad value: %v, %v", v, ok)
+	}
+}
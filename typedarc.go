@@ -0,0 +1,347 @@
+package lru
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// TypedARC is a thread-safe fixed size Adaptive Replacement Cache with
+// typed keys and values. It follows the same algorithm as ARCCache but
+// stores K and V directly, so values are not boxed in interfaces and no
+// type assertions are needed. The ghost lists B1 and B2 hold only keys.
+type TypedARC[K comparable, V any] struct {
+	size int // Size is the total capacity of the cache
+	p    int // P is the dynamic preference towards T1 or T2
+
+	t1 *typedLRU[K, V]        // T1 is the LRU for recently accessed items
+	b1 *typedLRU[K, struct{}] // B1 is the LRU for evictions from t1
+
+	t2 *typedLRU[K, V]        // T2 is the LRU for frequently accessed items
+	b2 *typedLRU[K, struct{}] // B2 is the LRU for evictions from t2
+
+	lock sync.RWMutex
+}
+
+// NewTypedARC creates a TypedARC of the given size
+func NewTypedARC[K comparable, V any](size int) (*TypedARC[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("Must provide a positive size")
+	}
+	c := &TypedARC[K, V]{
+		size: size,
+		p:    0,
+		t1:   newTypedLRU[K, V](size),
+		b1:   newTypedLRU[K, struct{}](size),
+		t2:   newTypedLRU[K, V](size),
+		b2:   newTypedLRU[K, struct{}](size),
+	}
+	return c, nil
+}
+
+// Get looks up a key's value from the cache.
+func (c *TypedARC[K, V]) Get(key K) (value V, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// If the value is contained in T1 (recent), then
+	// promote it to T2 (frequent)
+	if val, ok := c.t1.peek(key); ok {
+		c.t1.remove(key)
+		c.t2.add(key, val)
+		return val, ok
+	}
+
+	// Check if the value is contained in T2 (frequent)
+	if val, ok := c.t2.get(key); ok {
+		return val, ok
+	}
+
+	// No hit
+	return value, false
+}
+
+// Add adds a value to the cache.
+func (c *TypedARC[K, V]) Add(key K, value V) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check if the value is contained in T1 (recent), and potentially
+	// promote it to frequent T2
+	if c.t1.contains(key) {
+		c.t1.remove(key)
+		c.t2.add(key, value)
+		return
+	}
+
+	// Check if the value is already in T2 (frequent) and update it
+	if c.t2.contains(key) {
+		c.t2.add(key, value)
+		return
+	}
+
+	// Check if this value was recently evicted as part of the
+	// recently used list
+	if c.b1.contains(key) {
+		// T1 set is too small, increase P appropriately
+		delta := 1
+		b1Len := c.b1.len()
+		b2Len := c.b2.len()
+		if b2Len > b1Len {
+			delta = b2Len / b1Len
+		}
+		if c.p+delta >= c.size {
+			c.p = c.size
+		} else {
+			c.p += delta
+		}
+
+		// Potentially need to make room in the cache
+		if c.t1.len()+c.t2.len() >= c.size {
+			c.replace(false)
+		}
+
+		// Remove from B1 and add the key to the frequently used list
+		c.b1.remove(key)
+		c.t2.add(key, value)
+		return
+	}
+
+	// Check if this value was recently evicted as part of the
+	// frequently used list
+	if c.b2.contains(key) {
+		// T2 set is too small, decrease P appropriately
+		delta := 1
+		b1Len := c.b1.len()
+		b2Len := c.b2.len()
+		if b1Len > b2Len {
+			delta = b1Len / b2Len
+		}
+		if delta >= c.p {
+			c.p = 0
+		} else {
+			c.p -= delta
+		}
+
+		// Potentially need to make room in the cache
+		if c.t1.len()+c.t2.len() >= c.size {
+			c.replace(true)
+		}
+
+		// Remove from B2 and add the key to the frequently used list
+		c.b2.remove(key)
+		c.t2.add(key, value)
+		return
+	}
+
+	// Make room, keeping T1+B1 within size and the whole directory within
+	// twice size
+	c.trimForMiss()
+
+	// Add to the recently seen list
+	c.t1.add(key, value)
+}
+
+// trimForMiss makes room for a key that is in none of the lists, as
+// ARCCache.trimForMiss does
+func (c *TypedARC[K, V]) trimForMiss() {
+	t1Len, b1Len := c.t1.len(), c.b1.len()
+	if t1Len+b1Len >= c.size {
+		if t1Len < c.size {
+			c.b1.removeOldest()
+			if c.t1.len()+c.t2.len() >= c.size {
+				c.replace(false)
+			}
+		} else {
+			c.t1.removeOldest()
+		}
+		return
+	}
+	if t1Len+c.t2.len()+b1Len+c.b2.len() >= 2*c.size {
+		c.b2.removeOldest()
+	}
+	if c.t1.len()+c.t2.len() >= c.size {
+		c.replace(false)
+	}
+}
+
+// replace is used to adaptively evict from either T1 or T2
+// based on the current learned value of P
+func (c *TypedARC[K, V]) replace(b2ContainsKey bool) {
+	t1Len := c.t1.len()
+	if t1Len > 0 && (t1Len > c.p || (t1Len == c.p && b2ContainsKey)) {
+		if k, ok := c.t1.removeOldest(); ok {
+			c.b1.add(k, struct{}{})
+		}
+	} else {
+		if k, ok := c.t2.removeOldest(); ok {
+			c.b2.add(k, struct{}{})
+		}
+	}
+}
+
+// Len returns the number of cached entries
+func (c *TypedARC[K, V]) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.t1.len() + c.t2.len()
+}
+
+// Keys returns all the cached keys
+func (c *TypedARC[K, V]) Keys() []K {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append(c.t1.keys(), c.t2.keys()...)
+}
+
+// Remove is used to purge a key from the cache
+func (c *TypedARC[K, V]) Remove(key K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.t1.remove(key) {
+		return
+	}
+	if c.t2.remove(key) {
+		return
+	}
+	if c.b1.remove(key) {
+		return
+	}
+	c.b2.remove(key)
+}
+
+// Purge is used to clear the cache
+func (c *TypedARC[K, V]) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.t1.purge()
+	c.t2.purge()
+	c.b1.purge()
+	c.b2.purge()
+}
+
+// Contains is used to check if the cache contains a key
+// without updating recency or frequency.
+func (c *TypedARC[K, V]) Contains(key K) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.t1.contains(key) || c.t2.contains(key)
+}
+
+// Peek is used to inspect the cache value of a key
+// without updating recency or frequency.
+func (c *TypedARC[K, V]) Peek(key K) (value V, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if val, ok := c.t1.peek(key); ok {
+		return val, true
+	}
+	return c.t2.peek(key)
+}
+
+// typedLRU is a minimal non-thread-safe fixed size LRU with typed keys and
+// values, used for the lists of a TypedARC
+type typedLRU[K comparable, V any] struct {
+	size      int
+	evictList *list.List
+	items     map[K]*list.Element
+}
+
+// typedEntry is used to hold a value in the evictList of a typedLRU
+type typedEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newTypedLRU[K comparable, V any](size int) *typedLRU[K, V] {
+	return &typedLRU[K, V]{
+		size:      size,
+		evictList: list.New(),
+		items:     make(map[K]*list.Element),
+	}
+}
+
+// add adds or updates a value, evicting the oldest entry if needed
+func (c *typedLRU[K, V]) add(key K, value V) {
+	if ent, ok := c.items[key]; ok {
+		c.evictList.MoveToFront(ent)
+		ent.Value.(*typedEntry[K, V]).value = value
+		return
+	}
+	c.items[key] = c.evictList.PushFront(&typedEntry[K, V]{key: key, value: value})
+	if c.evictList.Len() > c.size {
+		c.removeOldest()
+	}
+}
+
+// get looks up a value and marks it as recently used
+func (c *typedLRU[K, V]) get(key K) (value V, ok bool) {
+	if ent, ok := c.items[key]; ok {
+		c.evictList.MoveToFront(ent)
+		return ent.Value.(*typedEntry[K, V]).value, true
+	}
+	return value, false
+}
+
+// peek looks up a value without updating its recent-ness
+func (c *typedLRU[K, V]) peek(key K) (value V, ok bool) {
+	if ent, ok := c.items[key]; ok {
+		return ent.Value.(*typedEntry[K, V]).value, true
+	}
+	return value, false
+}
+
+func (c *typedLRU[K, V]) contains(key K) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+// remove removes a key, returning if it was contained
+func (c *typedLRU[K, V]) remove(key K) bool {
+	if ent, ok := c.items[key]; ok {
+		c.evictList.Remove(ent)
+		delete(c.items, key)
+		return true
+	}
+	return false
+}
+
+// removeOldest removes the least recently used entry, returning its key
+func (c *typedLRU[K, V]) removeOldest() (key K, ok bool) {
+	ent := c.evictList.Back()
+	if ent == nil {
+		return key, false
+	}
+	key = ent.Value.(*typedEntry[K, V]).key
+	c.evictList.Remove(ent)
+	delete(c.items, key)
+	return key, true
+}
+
+// oldest returns the least recently used entry without removing it
+func (c *typedLRU[K, V]) oldest() (key K, value V, ok bool) {
+	ent := c.evictList.Back()
+	if ent == nil {
+		return key, value, false
+	}
+	kv := ent.Value.(*typedEntry[K, V])
+	return kv.key, kv.value, true
+}
+
+// keys returns the keys from oldest to newest
+func (c *typedLRU[K, V]) keys() []K {
+	keys := make([]K, 0, len(c.items))
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		keys = append(keys, ent.Value.(*typedEntry[K, V]).key)
+	}
+	return keys
+}
+
+func (c *typedLRU[K, V]) len() int {
+	return c.evictList.Len()
+}
+
+func (c *typedLRU[K, V]) purge() {
+	c.evictList.Init()
+	c.items = make(map[K]*list.Element)
+}
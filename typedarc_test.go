@@ -0,0 +1,127 @@
+package lru
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTypedARC(t *testing.T) {
+	l, err := NewTypedARC[int, string](128)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 256; i++ {
+		l.Add(i, string(rune('a'+i%26)))
+	}
+	if l.Len() != 128 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	for i, k := range l.Keys() {
+		if k != i+128 {
+			t.Fatalf("bad key: %v", k)
+		}
+		if v, ok := l.Peek(k); !ok || v != string(rune('a'+k%26)) {
+			t.Fatalf("bad value for %v: %v", k, v)
+		}
+	}
+	if _, ok := l.Get(0); ok || l.Contains(0) {
+		t.Fatalf("should be evicted")
+	}
+
+	l.Remove(200)
+	if l.Contains(200) {
+		t.Fatalf("should be deleted")
+	}
+	l.Purge()
+	if l.Len() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+
+	if _, err := NewTypedARC[int, int](0); err == nil {
+		t.Fatalf("should reject a zero size")
+	}
+}
+
+// Test that TypedARC makes the same decisions as ARCCache
+func TestTypedARC_MatchesARC(t *testing.T) {
+	typed, err := NewTypedARC[int64, int64](64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	arc, err := NewARC(64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 20000; i++ {
+		key := rand.Int63() % 256
+		switch rand.Int63() % 3 {
+		case 0:
+			typed.Add(key, key)
+			arc.Add(key, key)
+		case 1:
+			v1, ok1 := typed.Get(key)
+			v2, ok2 := arc.Get(key)
+			if ok1 != ok2 || (ok1 && v1 != v2.(int64)) {
+				t.Fatalf("get %d: %v %v, want %v %v", key, v1, ok1, v2, ok2)
+			}
+		default:
+			typed.Remove(key)
+			arc.Remove(key)
+		}
+		if typed.p != arc.p || typed.Len() != arc.Len() {
+			t.Fatalf("diverged: p %d/%d len %d/%d", typed.p, arc.p, typed.Len(), arc.Len())
+		}
+	}
+}
+
+// point is an 8-byte value used to check that small structs are stored
+// unboxed
+type point struct {
+	X, Y int32
+}
+
+func BenchmarkTypedARC_SmallStruct(b *testing.B) {
+	l, err := NewTypedARC[int, point](1024)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 1024; i++ {
+		l.Add(i, point{})
+		l.Get(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % 1024
+		l.Add(k, point{int32(i), int32(k)})
+		l.Get(k)
+	}
+}
+
+// Test that updating and reading cached small structs does not allocate
+func TestTypedARC_NoAllocs(t *testing.T) {
+	l, err := NewTypedARC[int, point](16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		l.Add(i, point{})
+		l.Get(i)
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		k := i % 16
+		l.Add(k, point{int32(i), int32(k)})
+		if v, ok := l.Get(k); !ok || v.X != int32(i) {
+			t.Fatalf("bad value: %v %v", v, ok)
+		}
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("bad allocs per op: %v", allocs)
+	}
+}
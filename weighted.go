@@ -0,0 +1,171 @@
+package lru
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// ErrCostExceedsCapacity is returned by WeightedLRU.TryAdd for a value
+// whose cost alone is more than the cache's max cost.
+var ErrCostExceedsCapacity = errors.New("cost exceeds cache capacity")
+
+// WeightedLRU is a thread-safe LRU cache with typed keys and values that is
+// bounded by the total cost of its values rather than their number. Each
+// value's cost is derived once, when it is added, and the least recently
+// used entries are evicted until the total is within maxCost.
+type WeightedLRU[K comparable, V any] struct {
+	maxCost int64
+	cost    func(V) int64
+	total   int64
+	lru     *typedLRU[K, weighted[V]]
+	lock    sync.Mutex
+}
+
+// weighted is a value stored along with its cost
+type weighted[V any] struct {
+	value V
+	cost  int64
+}
+
+// NewWeighted creates a WeightedLRU holding values whose costs sum to at
+// most maxCost
+func NewWeighted[K comparable, V any](maxCost int64, cost func(V) int64) (*WeightedLRU[K, V], error) {
+	if maxCost <= 0 {
+		return nil, errors.New("Must provide a positive max cost")
+	}
+	c := &WeightedLRU[K, V]{
+		maxCost: maxCost,
+		cost:    cost,
+		lru:     newTypedLRU[K, weighted[V]](math.MaxInt),
+	}
+	return c, nil
+}
+
+// Add adds a value to the cache, evicting the least recently used entries
+// until the total cost is within maxCost.  Returns true if an eviction
+// occurred. A value costing more than maxCost on its own is not added, and
+// any previous value for key is removed so it is not served in place of
+// the new one; use TryAdd to get an error instead.
+func (c *WeightedLRU[K, V]) Add(key K, value V) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cost := c.cost(value)
+	if cost > c.maxCost {
+		c.remove(key)
+		return false
+	}
+	return c.add(key, value, cost)
+}
+
+// add stores a value of the given cost, evicting until the total cost is
+// within maxCost. An existing entry is updated in place.
+func (c *WeightedLRU[K, V]) add(key K, value V, cost int64) bool {
+	if old, ok := c.lru.peek(key); ok {
+		c.total -= old.cost
+	}
+	c.lru.add(key, weighted[V]{value: value, cost: cost})
+	c.total += cost
+
+	evicted := false
+	for c.total > c.maxCost {
+		k, w, _ := c.lru.oldest()
+		c.lru.remove(k)
+		c.total -= w.cost
+		evicted = true
+	}
+	return evicted
+}
+
+// TryAdd adds a value to the cache like Add, but returns
+// ErrCostExceedsCapacity, leaving the cache unchanged, for a value costing
+// more than maxCost on its own. Returns true if an eviction occurred.
+func (c *WeightedLRU[K, V]) TryAdd(key K, value V) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cost := c.cost(value)
+	if cost > c.maxCost {
+		return false, ErrCostExceedsCapacity
+	}
+	return c.add(key, value, cost), nil
+}
+
+// Get looks up a key's value from the cache.
+func (c *WeightedLRU[K, V]) Get(key K) (value V, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w, ok := c.lru.get(key)
+	return w.value, ok
+}
+
+// GetMany looks up several keys under one lock, returning the values found
+// and the keys that were missing. Each hit is promoted as Get would.
+func (c *WeightedLRU[K, V]) GetMany(keys []K) (values map[K]V, missing []K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	values = make(map[K]V, len(keys))
+	for _, key := range keys {
+		if w, ok := c.lru.get(key); ok {
+			values[key] = w.value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	return values, missing
+}
+
+// Peek returns the key value without updating the "recently used"-ness of
+// the key.
+func (c *WeightedLRU[K, V]) Peek(key K) (value V, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w, ok := c.lru.peek(key)
+	return w.value, ok
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness.
+func (c *WeightedLRU[K, V]) Contains(key K) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.contains(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *WeightedLRU[K, V]) Remove(key K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remove(key)
+}
+
+// remove removes key and its cost, if present
+func (c *WeightedLRU[K, V]) remove(key K) {
+	if w, ok := c.lru.peek(key); ok {
+		c.lru.remove(key)
+		c.total -= w.cost
+	}
+}
+
+// Cost returns the total cost of the cached values.
+func (c *WeightedLRU[K, V]) Cost() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.total
+}
+
+// Len returns the number of items in the cache.
+func (c *WeightedLRU[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.len()
+}
+
+// Purge is used to completely clear the cache
+func (c *WeightedLRU[K, V]) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lru.purge()
+	c.total = 0
+}
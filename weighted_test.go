@@ -0,0 +1,151 @@
+package lru
+
+import "testing"
+
+func TestWeightedLRU(t *testing.T) {
+	l, err := NewWeighted[string, []byte](10, func(v []byte) int64 {
+		return int64(len(v))
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.Add("a", make([]byte, 4)) || l.Add("b", make([]byte, 4)) {
+		t.Errorf("should not have an eviction")
+	}
+	if l.Cost() != 8 || l.Len() != 2 {
+		t.Fatalf("bad cost %v or len %v", l.Cost(), l.Len())
+	}
+
+	// "a" becomes the most recently used, so "b" goes first
+	if v, ok := l.Get("a"); !ok || len(v) != 4 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+	if !l.Add("c", make([]byte, 5)) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Contains("b") || !l.Contains("a") || l.Cost() != 9 {
+		t.Errorf("bad eviction: cost %v", l.Cost())
+	}
+
+	// Updating a value replaces its cost
+	l.Add("a", make([]byte, 1))
+	if l.Cost() != 6 || l.Len() != 2 {
+		t.Errorf("bad cost %v or len %v", l.Cost(), l.Len())
+	}
+
+	// A value larger than the whole cache is not kept
+	if l.Add("c", make([]byte, 11)) || l.Contains("c") {
+		t.Errorf("oversized value should not be added")
+	}
+	if !l.Contains("a") || l.Cost() != 1 {
+		t.Errorf("other entries should be kept: cost %v", l.Cost())
+	}
+
+	l.Remove("a")
+	if l.Len() != 0 || l.Cost() != 0 {
+		t.Errorf("bad cost %v or len %v", l.Cost(), l.Len())
+	}
+	l.Add("d", make([]byte, 3))
+	l.Purge()
+	if l.Len() != 0 || l.Cost() != 0 {
+		t.Errorf("bad cost %v or len %v", l.Cost(), l.Len())
+	}
+
+	if _, err := NewWeighted[int, int](0, nil); err == nil {
+		t.Fatalf("should reject a zero max cost")
+	}
+}
+
+// Test that TryAdd rejects an oversized value without touching the cache
+func TestWeightedLRU_TryAdd(t *testing.T) {
+	l, err := NewWeighted[string, []byte](10, func(v []byte) int64 {
+		return int64(len(v))
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := l.TryAdd("a", make([]byte, 4)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add("b", make([]byte, 4))
+	if evicted, err := l.TryAdd("a", make([]byte, 11)); evicted || err != ErrCostExceedsCapacity {
+		t.Errorf("bad result: %v %v", evicted, err)
+	}
+	if v, ok := l.Peek("a"); !ok || len(v) != 4 || l.Cost() != 8 {
+		t.Errorf("cache should be unchanged: %v %v", v, l.Cost())
+	}
+	if evicted, err := l.TryAdd("c", make([]byte, 6)); !evicted || err != nil {
+		t.Errorf("bad result: %v %v", evicted, err)
+	}
+	if l.Contains("a") || l.Cost() != 10 {
+		t.Errorf("bad eviction: cost %v", l.Cost())
+	}
+}
+
+func TestWeightedLRU_GetMany(t *testing.T) {
+	l, err := NewWeighted[int, string](3, func(string) int64 { return 1 })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, "one")
+	l.Add(2, "two")
+	l.Add(3, "three")
+	values, missing := l.GetMany([]int{1, 4, 2})
+	if len(values) != 2 || values[1] != "one" || values[2] != "two" {
+		t.Errorf("bad values: %v", values)
+	}
+	if len(missing) != 1 || missing[0] != 4 {
+		t.Errorf("bad missing: %v", missing)
+	}
+
+	// The hits were promoted, so 3 is the oldest
+	l.Add(5, "five")
+	if l.Contains(3) || !l.Contains(1) || !l.Contains(2) {
+		t.Errorf("GetMany should have promoted its hits")
+	}
+}
+
+func BenchmarkWeightedLRU_SmallStruct(b *testing.B) {
+	l, err := NewWeighted[int, point](1024, func(point) int64 { return 1 })
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 1024; i++ {
+		l.Add(i, point{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % 1024
+		l.Add(k, point{int32(i), int32(k)})
+		l.Get(k)
+	}
+}
+
+// Test that updating and reading cached small structs does not allocate
+func TestWeightedLRU_NoAllocs(t *testing.T) {
+	l, err := NewWeighted[int, point](16, func(point) int64 { return 1 })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		l.Add(i, point{})
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		k := i % 16
+		l.Add(k, point{int32(i), int32(k)})
+		if v, ok := l.Get(k); !ok || v.X != int32(i) {
+			t.Fatalf("bad value: %v %v", v, ok)
+		}
+		i++
+	})
+	if allocs != 0 || l.Cost() != 16 {
+		t.Errorf("bad allocs per op %v or cost %v", allocs, l.Cost())
+	}
+}